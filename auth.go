@@ -0,0 +1,203 @@
+package caddygit
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Defaults for authentication.
+const (
+	DefaultHTTPUser = "caddy"
+	DefaultSSHUser  = "git"
+)
+
+// IsSSHURL tells if the given repository URL is to be cloned over SSH. Both
+// `ssh://` and scp-style (`user@host:path`) URLs are considered SSH URLs.
+func IsSSHURL(u string) bool {
+	ep, err := transport.NewEndpoint(u)
+	if err != nil {
+		return false
+	}
+
+	return ep.Protocol == "ssh"
+}
+
+// newAuthMethod creates the authentication method for the repository URL
+// from the given options. For SSH URLs the key file takes precedence over
+// the agent socket which takes precedence over the password.
+func newAuthMethod(opts *RepositoryOpts) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+
+	if ep.Protocol != "ssh" {
+		if opts.Username == "" && opts.Password == "" {
+			return nil, nil
+		}
+
+		username := DefaultHTTPUser
+		if opts.Username != "" {
+			username = opts.Username
+		}
+
+		return &http.BasicAuth{
+			Username: username,
+			Password: opts.Password,
+		}, nil
+	}
+
+	username := DefaultSSHUser
+	if opts.Username != "" {
+		username = opts.Username
+	} else if ep.User != "" {
+		username = ep.User
+	}
+
+	hostKeyCallback, err := newHostKeyCallback(opts)
+	if err != nil {
+		return nil, err
+	}
+	helper := gitssh.HostKeyCallbackHelper{HostKeyCallback: hostKeyCallback}
+
+	switch {
+	case opts.SSHKey != "":
+		auth, err := gitssh.NewPublicKeysFromFile(username, opts.SSHKey, opts.SSHKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ssh key: %v", err)
+		}
+
+		auth.HostKeyCallbackHelper = helper
+		return auth, nil
+
+	case opts.SSHAgent != "":
+		return &gitssh.PublicKeysCallback{
+			User:                  username,
+			Callback:              agentSigners(opts.SSHAgent),
+			HostKeyCallbackHelper: helper,
+		}, nil
+
+	case opts.Password != "":
+		return &gitssh.Password{
+			User:                  username,
+			Password:              opts.Password,
+			HostKeyCallbackHelper: helper,
+		}, nil
+	}
+
+	// Without any credentials, go-git falls back to the agent listening on
+	// `SSH_AUTH_SOCK`. Only the host key verification needs to be set.
+	if hostKeyCallback == nil {
+		return nil, nil
+	}
+
+	return &gitssh.PublicKeysCallback{
+		User:                  username,
+		Callback:              agentSigners(""),
+		HostKeyCallbackHelper: helper,
+	}, nil
+}
+
+// agentSigners returns a callback that asks the ssh-agent listening on the
+// given socket for signers. If socket is empty, `SSH_AUTH_SOCK` is used.
+// The agent is dialed everytime so that a restarted agent is picked up.
+func agentSigners(socket string) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		if socket == "" {
+			a, err := gitssh.NewSSHAgentAuth(DefaultSSHUser)
+			if err != nil {
+				return nil, err
+			}
+
+			return a.Callback()
+		}
+
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to ssh agent: %v", err)
+		}
+		defer conn.Close() // nolint:errcheck
+
+		keys, err := agent.NewClient(conn).List()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list ssh agent keys: %v", err)
+		}
+
+		signers := make([]ssh.Signer, 0, len(keys))
+		for _, key := range keys {
+			var pub ssh.PublicKey
+			pub, err = ssh.ParsePublicKey(key.Blob)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ssh agent key: %v", err)
+			}
+
+			signers = append(signers, &agentSigner{socket: socket, key: pub})
+		}
+
+		return signers, nil
+	}
+}
+
+// agentSigner is a key of the ssh-agent listening on socket. The agent is
+// dialed for every signature since the signer outlives the connection its
+// key was listed on.
+type agentSigner struct {
+	socket string
+	key    ssh.PublicKey
+}
+
+// PublicKey implements the ssh.Signer interface.
+func (s *agentSigner) PublicKey() ssh.PublicKey { return s.key }
+
+// Sign implements the ssh.Signer interface.
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to ssh agent: %v", err)
+	}
+	defer conn.Close() // nolint:errcheck
+
+	return agent.NewClient(conn).Sign(s.key, data)
+}
+
+// newHostKeyCallback creates the callback to verify the host key of SSH
+// server. Pinned fingerprints take precedence over the known_hosts file.
+// When neither is given, nil is returned and go-git uses the default
+// known_hosts files.
+func newHostKeyCallback(opts *RepositoryOpts) (ssh.HostKeyCallback, error) {
+	if len(opts.HostKeyFingerprints) > 0 {
+		fingerprints := make(map[string]struct{}, len(opts.HostKeyFingerprints))
+		for _, fp := range opts.HostKeyFingerprints {
+			fingerprints[fp] = struct{}{}
+		}
+
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if _, ok := fingerprints[ssh.FingerprintSHA256(key)]; ok {
+				return nil
+			}
+			if _, ok := fingerprints[ssh.FingerprintLegacyMD5(key)]; ok {
+				return nil
+			}
+
+			return fmt.Errorf("host key for %s not trusted: %s", hostname, ssh.FingerprintSHA256(key))
+		}, nil
+	}
+
+	if opts.KnownHosts != "" {
+		cb, err := gitssh.NewKnownHostsCallback(opts.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read known_hosts: %v", err)
+		}
+
+		return cb, nil
+	}
+
+	return nil, nil
+}
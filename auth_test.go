@@ -0,0 +1,100 @@
+package caddygit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveAgent serves an ssh-agent with a new key on a unix socket until the
+// test ends. It returns the socket and the public key.
+func serveAgent(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := agent.NewKeyring()
+	if err = keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "caddygit-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) }) // nolint:errcheck
+
+	socket := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() }) // nolint:errcheck
+
+	go func() {
+		for {
+			conn, err2 := ln.Accept()
+			if err2 != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()              // nolint:errcheck
+				agent.ServeAgent(keyring, conn) // nolint:errcheck
+			}()
+		}
+	}()
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return socket, sshPub
+}
+
+func TestAgentSigners(t *testing.T) {
+	socket, pub := serveAgent(t)
+
+	signers, err := agentSigners(socket)()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(signers) != 1 {
+		t.Fatalf("got %d signers; want 1", len(signers))
+	}
+
+	signer := signers[0]
+	if string(signer.PublicKey().Marshal()) != string(pub.Marshal()) {
+		t.Fatalf("got key %s; want %s", ssh.FingerprintSHA256(signer.PublicKey()), ssh.FingerprintSHA256(pub))
+	}
+
+	// The signers are used by go-git after the callback has returned.
+	data := []byte("session data")
+	sig, err := signer.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pub.Verify(data, sig)
+	if err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+}
+
+func TestAgentSignersNoAgent(t *testing.T) {
+	if _, err := agentSigners(filepath.Join(os.TempDir(), "caddygit-no-agent.sock"))(); err == nil {
+		t.Fatal("got <nil>; want an error")
+	}
+}
@@ -3,19 +3,26 @@
 // branch is taken by default).
 //
 // This is helpful while testing for webhook service. Currently implemented
-// for generic webhook service.
+// for generic webhook service. If a secret is given, the request is
+// authenticated with it the same way the generic hook expects.
 //
 // TODO(vrongmeal): implement mock webhook services for all providers.
 package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 )
 
 func main() {
@@ -35,12 +42,18 @@ func hooktestCmd() func() error {
 		payloadURL string
 		secret     string
 		refName    string
+		auth       genericAuth
 	)
 
 	fs.StringVar(&hookType, "t", "generic", "type of webhook client to start")
 	fs.StringVar(&payloadURL, "u", "", "payload url to send the request to")
 	fs.StringVar(&secret, "s", "", "secret to verify the origin of request")
 	fs.StringVar(&refName, "r", "refs/heads/master", "git reference name to send in update")
+	fs.StringVar(&auth.mode, "a", "signature", "generic hook auth mode: signature, bearer or query")
+	fs.StringVar(&auth.signatureHeader, "H", "X-Signature", "generic hook signature header")
+	fs.StringVar(&auth.signaturePrefix, "p", "sha256=", "generic hook signature prefix")
+	fs.StringVar(&auth.tokenParam, "q", "token", "generic hook token query parameter")
+	fs.StringVar(&auth.timestampHeader, "T", "", "generic hook timestamp header (not sent if empty)")
 
 	return func() error {
 		if err := fs.Parse(os.Args[1:]); err != nil {
@@ -49,13 +62,14 @@ func hooktestCmd() func() error {
 
 		var (
 			headers  http.Header
+			query    url.Values
 			jsonBody []byte
 			err      error
 		)
 
 		switch hookType {
 		case "generic":
-			headers, jsonBody, err = genericHook(secret, refName)
+			headers, query, jsonBody, err = genericHook(secret, refName, &auth)
 			if err != nil {
 				return err
 			}
@@ -63,32 +77,65 @@ func hooktestCmd() func() error {
 			return fmt.Errorf("invalid hook type: %s", hookType)
 		}
 
-		return sendRequest(payloadURL, headers, jsonBody)
+		return sendRequest(payloadURL, query, headers, jsonBody)
 	}
 }
 
-// genericHook returns the headers and json body for generic hook service.
-func genericHook(secret, refName string) (headers http.Header, jsonBody []byte, _ error) {
+// genericAuth is the configuration of the generic hook to authenticate the
+// request with.
+type genericAuth struct {
+	mode            string
+	signatureHeader string
+	signaturePrefix string
+	tokenParam      string
+	timestampHeader string
+}
+
+// genericHook returns the headers, query and json body for generic hook
+// service.
+func genericHook(secret, refName string, auth *genericAuth) (headers http.Header, query url.Values, jsonBody []byte, _ error) {
 	reqBody, err := json.Marshal(map[string]string{"ref": refName})
 	if err != nil {
-		return nil, nil, fmt.Errorf("unexpected error while parsing JSON: %v", err)
+		return nil, nil, nil, fmt.Errorf("unexpected error while parsing JSON: %v", err)
 	}
 
-	return nil, reqBody, nil
-}
+	if secret == "" {
+		return nil, nil, reqBody, nil
+	}
 
-// sendRequest sends the request to payload url with the given headers and body.
-func sendRequest(payloadURL string, headers http.Header, jsonBody []byte) error {
-	req, err := http.NewRequest(http.MethodPost, payloadURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return fmt.Errorf("cannot create request: %v", err)
+	headers = http.Header{}
+	query = url.Values{}
+	payload := reqBody
+
+	if auth.timestampHeader != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers.Set(auth.timestampHeader, timestamp)
+		payload = append([]byte(timestamp+"."), reqBody...)
 	}
 
-	req.Header = headers
-	if headers == nil {
-		req.Header = http.Header{}
+	switch auth.mode {
+	case "signature":
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		headers.Set(auth.signatureHeader, auth.signaturePrefix+hex.EncodeToString(mac.Sum(nil)))
+	case "bearer":
+		headers.Set("Authorization", "Bearer "+secret)
+	case "query":
+		query.Set(auth.tokenParam, secret)
+	default:
+		return nil, nil, nil, fmt.Errorf("invalid auth mode: %s", auth.mode)
+	}
+
+	return headers, query, reqBody, nil
+}
+
+// sendRequest sends the request to payload url with the given query, headers
+// and body.
+func sendRequest(payloadURL string, query url.Values, headers http.Header, jsonBody []byte) error {
+	req, err := newRequest(payloadURL, query, headers, jsonBody)
+	if err != nil {
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -108,3 +155,28 @@ Body:        %s
 
 	return nil
 }
+
+// newRequest creates the request to payload url with the given query,
+// headers and body.
+func newRequest(payloadURL string, query url.Values, headers http.Header, jsonBody []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, payloadURL, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %v", err)
+	}
+
+	if len(query) > 0 {
+		q := req.URL.Query()
+		for key, vals := range query {
+			q[key] = vals
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+
+	req.Header = headers
+	if headers == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit"
+	"github.com/vrongmeal/caddygit/services/webhook"
+	"github.com/vrongmeal/caddygit/services/webhook/generic"
+)
+
+// TestGenericHook ensures that the requests of hooktest are accepted by the
+// generic hook configured the same way.
+func TestGenericHook(t *testing.T) {
+	const secret = "It's a Secret to Everybody"
+
+	tests := []struct {
+		name string
+		auth genericAuth
+	}{
+		{
+			name: "signature",
+			auth: genericAuth{mode: "signature"},
+		},
+		{
+			name: "signature with timestamp",
+			auth: genericAuth{mode: "signature", timestampHeader: "X-Timestamp"},
+		},
+		{
+			name: "signature with custom header",
+			auth: genericAuth{mode: "signature", signatureHeader: "X-Hub-Signature", signaturePrefix: "v1="},
+		},
+		{
+			name: "bearer",
+			auth: genericAuth{mode: "bearer"},
+		},
+		{
+			name: "query",
+			auth: genericAuth{mode: "query", tokenParam: "key"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &generic.Webhook{
+				Auth:            tt.auth.mode,
+				SignatureHeader: tt.auth.signatureHeader,
+				SignaturePrefix: tt.auth.signaturePrefix,
+				TokenParam:      tt.auth.tokenParam,
+				TimestampHeader: tt.auth.timestampHeader,
+			}
+			if err := hook.Provision(caddy.Context{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// hooktest uses the same defaults as the hook.
+			auth := tt.auth
+			auth.signatureHeader = hook.SignatureHeader
+			auth.signaturePrefix = hook.SignaturePrefix
+			auth.tokenParam = hook.TokenParam
+
+			headers, query, body, err := genericHook(secret, "refs/heads/master", &auth)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := newRequest("http://localhost/", query, headers, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hc := &webhook.HookConf{
+				Secret: secret,
+				RepoInfo: caddygit.RepositoryInfo{
+					ReferenceName: plumbing.NewBranchReferenceName("master"),
+				},
+			}
+
+			sc, err := hook.Handle(req, hc)
+			if err != nil || sc != http.StatusOK {
+				t.Fatalf("got %d, %v; want %d, <nil>", sc, err, http.StatusOK)
+			}
+
+			// The request is rejected with another secret.
+			headers, query, body, err = genericHook(secret+"!", "refs/heads/master", &auth)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err = newRequest("http://localhost/", query, headers, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sc, err = hook.Handle(req, hc); err == nil {
+				t.Fatalf("got %d, <nil>; want an error", sc)
+			}
+		})
+	}
+}
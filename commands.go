@@ -2,19 +2,49 @@ package caddygit
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
 )
 
+// DefaultRetryBackoff is the time waited before retrying a failed command
+// for the first time. It doubles with every retry.
+const DefaultRetryBackoff = time.Second
+
 // Commander runs the given command in order. If a command throws an error,
-// it terminates the execution of further commands if `ExitOnError` is set
-// true. An error func can also be provided which is run when there's an
-// error in running command.
+// it terminates the execution of further commands unless the command has
+// `ContinueOnError` set true. An error func can also be provided which is
+// run when there's an error in running command.
 type Commander struct {
 	commands []Command
 
-	OnError func(error)
-	OnStart func(Command)
+	// Dir is the working directory of the commands. If empty, the commands
+	// run in the current directory of the process.
+	Dir string
+
+	// Env are the environment variables (`KEY=value`) passed to all the
+	// commands along-with the environment of the process.
+	Env []string
+
+	// Supervisor runs the service commands. If nil, service commands are
+	// run as async commands.
+	Supervisor *Supervisor
+
+	// Output receives the output of the commands along-with OnOutput. The
+	// commands keep writing to the Output that was set when they were
+	// started, so it can be changed for every run.
+	Output func(cmd Command, stream, line string)
+
+	OnError  func(error)
+	OnStart  func(Command)
+	OnOutput func(cmd Command, stream, line string)
 }
 
 // AddCommand adds a command into the commander.
@@ -27,20 +57,43 @@ func (c *Commander) AddCommand(cmd Command) {
 	c.commands = append(c.commands, cmd)
 }
 
-// Run runs the commands.
+// Run runs the commands. It stops at the first command that fails without
+// `ContinueOnError` and returns a CommandsError with errors of all the
+// commands that failed till then. The service commands are (re)started by
+// the supervisor only after all the other commands have run, so that the
+// running services are left as they are if the commands fail.
 func (c *Commander) Run(ctx context.Context) error {
-	for _, cmd := range c.commands {
+	var errs CommandsError
+	var services []int
+
+	for i, cmd := range c.commands {
 		if cmd.String() == "" {
 			continue
 		}
 
+		if cmd.Service && c.Supervisor != nil {
+			services = append(services, i)
+			continue
+		}
+
+		c.prepare(&cmd)
 		if c.OnStart != nil {
 			c.OnStart(cmd)
 		}
 
-		if err := cmd.Execute(ctx); err != nil {
+		if err := c.execute(ctx, &cmd); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			cmdErr := &CommandError{Command: cmd.String(), Err: err}
 			if c.OnError != nil {
-				c.OnError(err)
+				c.OnError(cmdErr)
+			}
+
+			errs = append(errs, cmdErr)
+			if !cmd.ContinueOnError {
+				return errs
 			}
 		}
 
@@ -53,14 +106,134 @@ func (c *Commander) Run(ctx context.Context) error {
 		}
 	}
 
+	for _, i := range services {
+		cmd := c.commands[i]
+		c.prepare(&cmd)
+		if c.OnStart != nil {
+			c.OnStart(cmd)
+		}
+
+		c.Supervisor.Start(i, cmd)
+	}
+
 	return nil
 }
 
+// prepare sets the directory, environment and output of the commander on
+// the command.
+func (c *Commander) prepare(cmd *Command) {
+	cmd.baseDir = c.Dir
+	cmd.baseEnv = c.Env
+	if c.OnOutput != nil || c.Output != nil {
+		cmd.output = c.outputFunc(*cmd)
+	}
+}
+
+// outputFunc returns the function that passes the output of cmd to the
+// Output and OnOutput funcs.
+func (c *Commander) outputFunc(cmd Command) func(stream, line string) {
+	output, onOutput := c.Output, c.OnOutput
+	return func(stream, line string) {
+		if output != nil {
+			output(cmd, stream, line)
+		}
+
+		if onOutput != nil {
+			onOutput(cmd, stream, line)
+		}
+	}
+}
+
+// execute runs the command and retries it with backoff if it fails.
+func (c *Commander) execute(ctx context.Context, cmd *Command) error {
+	backoff := time.Duration(cmd.RetryBackoff)
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := cmd.Execute(ctx)
+		if err == nil || attempt >= cmd.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// CommandError is the error returned by a command run by the commander.
+type CommandError struct {
+	Command string
+	Err     error
+}
+
+// Error implements the error interface.
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command '%s': %v", e.Command, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// CommandsError aggregates the errors of all the commands that failed in a
+// single run of the commander.
+type CommandsError []*CommandError
+
+// Error implements the error interface.
+func (e CommandsError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
 // Command is the representation of a shell command that can be run async
 // or synchronously depending on the async parameter.
 type Command struct {
 	Args  []string `json:"command,omitempty"`
 	Async bool     `json:"async,omitempty"`
+
+	// Service is a long-running command that is supervised. It's restarted
+	// if it exits and the previous instance is stopped gracefully, i.e.,
+	// SIGTERM and then SIGKILL after the GracePeriod (defaults to 10
+	// seconds), before starting it again on every update.
+	Service     bool           `json:"service,omitempty"`
+	GracePeriod caddy.Duration `json:"grace_period,omitempty"`
+
+	// Env are the environment variables set for the command. These override
+	// the environment variables set by the commander.
+	Env map[string]string `json:"env,omitempty"`
+
+	// Dir is the working directory of the command. A relative directory is
+	// relative to the working directory set by the commander.
+	Dir string `json:"dir,omitempty"`
+
+	// Timeout after which the command is killed. Not applicable for async
+	// commands.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// Retries is the number of times the command is retried if it fails.
+	// RetryBackoff is the time waited before the first retry which doubles
+	// with every retry. Defaults to 1 second.
+	Retries      int            `json:"retries,omitempty"`
+	RetryBackoff caddy.Duration `json:"retry_backoff,omitempty"`
+
+	// ContinueOnError runs the next commands even if this command fails.
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+
+	baseDir string
+	baseEnv []string
+	output  func(stream, line string)
 }
 
 func (c *Command) cmd() *exec.Cmd {
@@ -77,11 +250,75 @@ func (c *Command) cmd() *exec.Cmd {
 	}
 
 	command := exec.Command(name, args...) // nolint:gosec
+	command.Dir = c.dir()
+	command.Env = c.env()
 	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	return command
 }
 
+// dir returns the working directory of the command.
+func (c *Command) dir() string {
+	if c.Dir == "" {
+		return c.baseDir
+	}
+
+	if filepath.IsAbs(c.Dir) {
+		return c.Dir
+	}
+
+	return filepath.Join(c.baseDir, c.Dir)
+}
+
+// env returns the environment of the command. Variables set later take
+// precedence over the former ones.
+func (c *Command) env() []string {
+	env := append(os.Environ(), c.baseEnv...)
+
+	keys := make([]string, 0, len(c.Env))
+	for key := range c.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		env = append(env, key+"="+c.Env[key])
+	}
+
+	return env
+}
+
+// start starts the command and returns the channel which receives the
+// error when the process exits. The process is waited for in background
+// and its output is flushed once it exits.
+func (c *Command) start() (*exec.Cmd, <-chan error, error) {
+	ex := c.cmd()
+
+	flush := func() {}
+	if c.output != nil {
+		stdout := &lineWriter{fn: func(line string) { c.output(Stdout, line) }}
+		stderr := &lineWriter{fn: func(line string) { c.output(Stderr, line) }}
+		ex.Stdout, ex.Stderr = stdout, stderr
+		flush = func() {
+			stdout.Flush()
+			stderr.Flush()
+		}
+	}
+
+	if err := ex.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	wait := make(chan error, 1)
+	go func() {
+		err := ex.Wait()
+		flush()
+		wait <- err
+	}()
+
+	return ex, wait, nil
+}
+
 // String returns the command in a string format.
 func (c *Command) String() string {
 	return c.cmd().String()
@@ -90,31 +327,35 @@ func (c *Command) String() string {
 // Execute runs the command with the given context. The process is killed
 // when the context is canceled.
 func (c *Command) Execute(ctx context.Context) error {
-	stream := make(chan error)
-
-	cmd := c.cmd()
-
-	if err := cmd.Start(); err != nil {
+	cmd, stream, err := c.start()
+	if err != nil {
 		return err
 	}
 
-	if c.Async {
+	if c.Async || c.Service {
 		// exit if the process is run asynchronously
 		return nil
 	}
 
-	go func(ex *exec.Cmd, err chan<- error) {
-		err <- ex.Wait()
-	}(cmd, stream)
+	runCtx := ctx
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, time.Duration(c.Timeout))
+		defer cancel()
+	}
 
 	select {
-	case <-ctx.Done():
+	case <-runCtx.Done():
 		// Elegantly close the parent along-with the children.
 		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
 		if err != nil {
 			return err
 		}
 
+		if ctx.Err() == nil {
+			return fmt.Errorf("timed out after %s", time.Duration(c.Timeout))
+		}
+
 		return ctx.Err()
 
 	case err := <-stream:
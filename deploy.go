@@ -0,0 +1,81 @@
+package caddygit
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Environment variables describing the deploy which are passed to commands.
+const (
+	EnvOldCommit = "CADDYGIT_OLD_COMMIT"
+	EnvNewCommit = "CADDYGIT_NEW_COMMIT"
+	EnvRef       = "CADDYGIT_REF"
+	EnvTag       = "CADDYGIT_TAG"
+	EnvAuthor    = "CADDYGIT_AUTHOR"
+	EnvMessage   = "CADDYGIT_MESSAGE"
+	EnvURL       = "CADDYGIT_URL"
+)
+
+// DeployInfo tells information about the commit being deployed.
+type DeployInfo struct {
+	// OldCommit is the commit that was last deployed successfully. It's
+	// zero when the repository is being setup.
+	OldCommit plumbing.Hash
+	NewCommit plumbing.Hash
+
+	ReferenceName plumbing.ReferenceName
+	Tag           plumbing.ReferenceName
+
+	Author  string
+	Message string
+	URL     string
+}
+
+// DeployInfo returns information about the deploy of commit checked out
+// in the worktree, given the commit checked out before.
+func (r *Repository) DeployInfo(old plumbing.Hash) (*DeployInfo, error) {
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := r.repo.CommitObject(head)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DeployInfo{
+		OldCommit:     old,
+		NewCommit:     head,
+		ReferenceName: r.refName,
+		Author:        commit.Author.String(),
+		Message:       strings.TrimSpace(commit.Message),
+		URL:           r.url,
+	}
+
+	if r.refName.IsTag() {
+		d.Tag = r.refName
+	}
+
+	return d, nil
+}
+
+// Env returns the deploy information as environment variables of the form
+// `KEY=value`.
+func (d *DeployInfo) Env() []string {
+	var old string
+	if !d.OldCommit.IsZero() {
+		old = d.OldCommit.String()
+	}
+
+	return []string{
+		EnvOldCommit + "=" + old,
+		EnvNewCommit + "=" + d.NewCommit.String(),
+		EnvRef + "=" + d.ReferenceName.String(),
+		EnvTag + "=" + d.Tag.Short(),
+		EnvAuthor + "=" + d.Author,
+		EnvMessage + "=" + d.Message,
+		EnvURL + "=" + d.URL,
+	}
+}
@@ -3,7 +3,15 @@ module github.com/vrongmeal/caddygit
 go 1.14
 
 require (
-	github.com/caddyserver/caddy/v2 v2.1.1
+	cloud.google.com/go v0.54.0 // indirect
+	github.com/Masterminds/semver/v3 v3.1.0
+	github.com/caddyserver/caddy/v2 v2.4.0
+	github.com/dustin/go-humanize v1.0.1-0.20200219035652-afde56e7acac
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-git/go-git/v5 v5.1.0
-	go.uber.org/zap v1.15.0
+	github.com/klauspost/cpuid v1.3.0 // indirect
+	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
+	google.golang.org/protobuf v1.25.0 // indirect
+	howett.net/plist v0.0.0-20200419221736-3b63eb3a43b5 // indirect
 )
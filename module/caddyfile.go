@@ -0,0 +1,445 @@
+package module
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+
+	"github.com/vrongmeal/caddygit"
+)
+
+// UnmarshalCaddyfile sets up the client from Caddyfile tokens. Syntax:
+//
+// 	client {
+// 		repo           <url> [<path>] { ... }
+// 		command        <args...> { ... }
+// 		service        <type> { ... }
+// 		release        [<dir>] { ... }
+// 		output         { ... }
+// 		update_backoff <backoff> [<max_backoff>]
+// 	}
+//
+// The `command` sub-directive can be repeated to run multiple commands.
+func (c *Client) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			handled, err := c.unmarshalCaddyfileOption(d)
+			if err != nil {
+				return err
+			}
+
+			if !handled {
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfileOption parses the sub-directive of client the dispenser
+// is at. It returns false if the sub-directive is not of a client.
+func (c *Client) unmarshalCaddyfileOption(d *caddyfile.Dispenser) (bool, error) {
+	switch d.Val() {
+	case "repo":
+		if err := UnmarshalRepositoryOpts(d, &c.RepositoryOpts); err != nil {
+			return true, err
+		}
+
+	case "command":
+		cmd, err := UnmarshalCommand(d)
+		if err != nil {
+			return true, err
+		}
+		c.RawCommands = append(c.RawCommands, cmd)
+
+	case "service":
+		raw, err := UnmarshalModuleRaw(d, "git.services")
+		if err != nil {
+			return true, err
+		}
+		c.ServiceRaw = raw
+
+	case "release":
+		opts, err := UnmarshalReleaseOpts(d)
+		if err != nil {
+			return true, err
+		}
+		c.ReleaseOpts = opts
+
+	case "output":
+		opts, err := UnmarshalOutputOpts(d)
+		if err != nil {
+			return true, err
+		}
+		c.OutputOpts = opts
+
+	case "update_backoff":
+		if err := UnmarshalUpdateBackoff(d, &c.UpdateBackoff, &c.MaxUpdateBackoff); err != nil {
+			return true, err
+		}
+
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// UnmarshalRepositoryOpts parses the `repo` directive into opts. Syntax:
+//
+// 	repo [<url> [<path>]] {
+// 		url                   <url>
+// 		path                  <path>
+// 		branch                <branch>
+// 		auth_user             <username>
+// 		auth_secret           <password>
+// 		ssh_key               <file> [<passphrase>]
+// 		ssh_key_passphrase    <passphrase>
+// 		ssh_agent             <socket>
+// 		known_hosts           <file>
+// 		host_key_fingerprints <fingerprints...>
+// 		trusted_pgp_keys      <files...>
+// 		trusted_ssh_keys      <key>
+// 		tag_constraint        <constraint>
+// 		single_branch
+// 		depth                 <depth>
+// 	}
+//
+// The `trusted_ssh_keys` sub-directive can be repeated to trust multiple
+// keys.
+func UnmarshalRepositoryOpts(d *caddyfile.Dispenser, opts *caddygit.RepositoryOpts) error {
+	args := d.RemainingArgs()
+	switch len(args) {
+	case 0:
+	case 2:
+		opts.Path = args[1]
+		fallthrough
+	case 1:
+		opts.URL = args[0]
+	default:
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if err := unmarshalRepositoryOption(d, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unmarshalRepositoryOption parses the sub-directive of repo the dispenser
+// is at into opts.
+func unmarshalRepositoryOption(d *caddyfile.Dispenser, opts *caddygit.RepositoryOpts) error {
+	switch d.Val() {
+	case "url":
+		return singleArg(d, &opts.URL)
+	case "path":
+		return singleArg(d, &opts.Path)
+	case "branch":
+		return singleArg(d, &opts.Branch)
+	case "auth_user":
+		return singleArg(d, &opts.Username)
+	case "auth_secret":
+		return singleArg(d, &opts.Password)
+	case "ssh_key":
+		args := d.RemainingArgs()
+		switch len(args) {
+		case 2:
+			opts.SSHKeyPassphrase = args[1]
+			fallthrough
+		case 1:
+			opts.SSHKey = args[0]
+		default:
+			return d.ArgErr()
+		}
+	case "ssh_key_passphrase":
+		return singleArg(d, &opts.SSHKeyPassphrase)
+	case "ssh_agent":
+		return singleArg(d, &opts.SSHAgent)
+	case "known_hosts":
+		return singleArg(d, &opts.KnownHosts)
+	case "host_key_fingerprints":
+		return multipleArgs(d, &opts.HostKeyFingerprints)
+	case "trusted_pgp_keys":
+		return multipleArgs(d, &opts.TrustedPGPKeys)
+	case "trusted_ssh_keys":
+		// The key can be given unquoted as it appears in the
+		// authorized_keys file.
+		var key string
+		if err := joinedArgs(d, &key); err != nil {
+			return err
+		}
+		opts.TrustedSSHKeys = append(opts.TrustedSSHKeys, key)
+	case "tag_constraint":
+		// Constraints separated by space can be given unquoted.
+		return joinedArgs(d, &opts.TagConstraint)
+	case "single_branch":
+		opts.SingleBranch = true
+		return noArgs(d)
+	case "depth":
+		return intArg(d, &opts.Depth)
+	default:
+		return d.Errf("unrecognized repo subdirective '%s'", d.Val())
+	}
+
+	return nil
+}
+
+// UnmarshalCommand parses the `command` directive. Syntax:
+//
+// 	command <args...> {
+// 		async
+// 		service
+// 		grace_period      <duration>
+// 		env               <key> <value>
+// 		dir               <dir>
+// 		timeout           <duration>
+// 		retries           <retries>
+// 		retry_backoff     <duration>
+// 		continue_on_error
+// 	}
+//
+// The `env` sub-directive can be repeated to set multiple variables.
+func UnmarshalCommand(d *caddyfile.Dispenser) (caddygit.Command, error) {
+	cmd := caddygit.Command{Args: d.RemainingArgs()}
+	if len(cmd.Args) == 0 {
+		return cmd, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		var err error
+
+		switch d.Val() {
+		case "async":
+			err = noArgs(d)
+			cmd.Async = true
+		case "service":
+			err = noArgs(d)
+			cmd.Service = true
+		case "grace_period":
+			err = durationArg(d, &cmd.GracePeriod)
+		case "env":
+			var key, value string
+			if !d.AllArgs(&key, &value) {
+				return cmd, d.ArgErr()
+			}
+			if cmd.Env == nil {
+				cmd.Env = make(map[string]string)
+			}
+			cmd.Env[key] = value
+		case "dir":
+			err = singleArg(d, &cmd.Dir)
+		case "timeout":
+			err = durationArg(d, &cmd.Timeout)
+		case "retries":
+			err = intArg(d, &cmd.Retries)
+		case "retry_backoff":
+			err = durationArg(d, &cmd.RetryBackoff)
+		case "continue_on_error":
+			err = noArgs(d)
+			cmd.ContinueOnError = true
+		default:
+			return cmd, d.Errf("unrecognized command subdirective '%s'", d.Val())
+		}
+
+		if err != nil {
+			return cmd, err
+		}
+	}
+
+	return cmd, nil
+}
+
+// UnmarshalReleaseOpts parses the `release` directive. Syntax:
+//
+// 	release [<dir>] {
+// 		dir  <dir>
+// 		keep <releases>
+// 	}
+func UnmarshalReleaseOpts(d *caddyfile.Dispenser) (*caddygit.ReleaseOpts, error) {
+	opts := &caddygit.ReleaseOpts{}
+	if d.NextArg() {
+		opts.Dir = d.Val()
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		var err error
+
+		switch d.Val() {
+		case "dir":
+			err = singleArg(d, &opts.Dir)
+		case "keep":
+			err = intArg(d, &opts.Keep)
+		default:
+			return nil, d.Errf("unrecognized release subdirective '%s'", d.Val())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// UnmarshalOutputOpts parses the `output` directive. Syntax:
+//
+// 	output {
+// 		lines     <lines>
+// 		log_dir   <dir>
+// 		keep_logs <files>
+// 	}
+func UnmarshalOutputOpts(d *caddyfile.Dispenser) (*caddygit.OutputOpts, error) {
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	opts := &caddygit.OutputOpts{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		var err error
+
+		switch d.Val() {
+		case "lines":
+			err = intArg(d, &opts.Lines)
+		case "log_dir":
+			err = singleArg(d, &opts.Dir)
+		case "keep_logs":
+			err = intArg(d, &opts.Keep)
+		default:
+			return nil, d.Errf("unrecognized output subdirective '%s'", d.Val())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// UnmarshalModuleRaw parses the `<directive> <name> { ... }` tokens into the
+// JSON of module `<namespace>.<name>` with the name set as its `type`.
+func UnmarshalModuleRaw(d *caddyfile.Dispenser, namespace string) (json.RawMessage, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	name := d.Val()
+
+	unm, err := caddyfile.UnmarshalModule(d, namespace+"."+name)
+	if err != nil {
+		return nil, err
+	}
+
+	return caddyconfig.JSONModuleObject(unm, "type", name, nil), nil
+}
+
+// singleArg sets the only argument of the directive to val.
+func singleArg(d *caddyfile.Dispenser, val *string) error {
+	if !d.AllArgs(val) {
+		return d.ArgErr()
+	}
+
+	return nil
+}
+
+// multipleArgs appends the arguments of the directive to vals.
+func multipleArgs(d *caddyfile.Dispenser, vals *[]string) error {
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		return d.ArgErr()
+	}
+
+	*vals = append(*vals, args...)
+	return nil
+}
+
+// joinedArgs sets the arguments of the directive joined by spaces to val.
+func joinedArgs(d *caddyfile.Dispenser, val *string) error {
+	*val = strings.Join(d.RemainingArgs(), " ")
+	if *val == "" {
+		return d.ArgErr()
+	}
+
+	return nil
+}
+
+// noArgs ensures the directive has no arguments.
+func noArgs(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	return nil
+}
+
+// UnmarshalUpdateBackoff parses the `update_backoff` directive into the
+// backoff and maximum backoff. Syntax:
+//
+// 	update_backoff <backoff> [<max_backoff>]
+func UnmarshalUpdateBackoff(d *caddyfile.Dispenser, backoff, maxBackoff *caddy.Duration) error {
+	args := d.RemainingArgs()
+	if len(args) == 0 || len(args) > 2 {
+		return d.ArgErr()
+	}
+
+	durs := []*caddy.Duration{backoff, maxBackoff}
+	for i, arg := range args {
+		dur, err := caddy.ParseDuration(arg)
+		if err != nil {
+			return d.Errf("invalid duration '%s': %v", arg, err)
+		}
+
+		*durs[i] = caddy.Duration(dur)
+	}
+
+	return nil
+}
+
+// intArg parses the only argument of the directive as an integer.
+func intArg(d *caddyfile.Dispenser, val *int) error {
+	var s string
+	if err := singleArg(d, &s); err != nil {
+		return err
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return d.Errf("invalid number '%s': %v", s, err)
+	}
+
+	*val = i
+	return nil
+}
+
+// durationArg parses the only argument of the directive as a duration.
+func durationArg(d *caddyfile.Dispenser, val *caddy.Duration) error {
+	var s string
+	if err := singleArg(d, &s); err != nil {
+		return err
+	}
+
+	dur, err := caddy.ParseDuration(s)
+	if err != nil {
+		return d.Errf("invalid duration '%s': %v", s, err)
+	}
+
+	*val = caddy.Duration(dur)
+	return nil
+}
+
+// Interface guard.
+var _ caddyfile.Unmarshaler = (*Client)(nil)
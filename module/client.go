@@ -6,13 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"go.uber.org/zap"
 
 	"github.com/vrongmeal/caddygit"
@@ -29,9 +32,37 @@ type Client struct {
 	RawCommands    []caddygit.Command      `json:"commands_after,omitempty"`
 	ServiceRaw     json.RawMessage         `json:"service,omitempty" caddy:"namespace=git.services inline_key=type"`
 
+	// ReleaseOpts, if set, deploys every commit in its own release directory
+	// and switches the `current` symlink to it once the commands succeed.
+	ReleaseOpts *caddygit.ReleaseOpts `json:"release,omitempty"`
+
+	// OutputOpts configures how the output of commands is kept.
+	OutputOpts *caddygit.OutputOpts `json:"output,omitempty"`
+
+	// UpdateBackoff is the time to wait before the next update after an
+	// update fails. It doubles with every consecutive failure up to
+	// MaxUpdateBackoff. Default to 10s and 10m.
+	UpdateBackoff    caddy.Duration `json:"update_backoff,omitempty"`
+	MaxUpdateBackoff caddy.Duration `json:"max_update_backoff,omitempty"`
+
 	Repo          *caddygit.Repository `json:"-"`
 	CommandsAfter *caddygit.Commander  `json:"-"`
 	Service       caddygit.Service     `json:"-"`
+	Releases      *caddygit.Releases   `json:"-"`
+	Output        *caddygit.OutputLog  `json:"-"`
+
+	// mu makes sure that only one update runs at a time.
+	mu sync.Mutex
+
+	// deployed is the commit that was last deployed successfully. It's
+	// zero until a deploy succeeds.
+	deployed plumbing.Hash
+
+	// failures is the number of consecutive updates that have failed, the
+	// last of which failed at failedAt.
+	failMu   sync.Mutex
+	failures int
+	failedAt time.Time
 }
 
 // Provision set's up cl's configuration.
@@ -46,8 +77,13 @@ func (c *Client) Provision(ctx caddy.Context, log *zap.Logger, repl *caddy.Repla
 		&c.RepositoryOpts.Branch,
 		&c.RepositoryOpts.Password,
 		&c.RepositoryOpts.Path,
+		&c.RepositoryOpts.TagConstraint,
 		&c.RepositoryOpts.URL,
 		&c.RepositoryOpts.Username,
+		&c.RepositoryOpts.SSHKey,
+		&c.RepositoryOpts.SSHKeyPassphrase,
+		&c.RepositoryOpts.SSHAgent,
+		&c.RepositoryOpts.KnownHosts,
 	}
 
 	for _, field := range replaceableFields {
@@ -59,6 +95,17 @@ func (c *Client) Provision(ctx caddy.Context, log *zap.Logger, repl *caddy.Repla
 		*field = actual
 	}
 
+	// Local repositories are cloned from their absolute path so that the
+	// URL doesn't depend on the working directory.
+	if isLocalPath(c.RepositoryOpts.URL) {
+		url, err := filepath.Abs(c.RepositoryOpts.URL)
+		if err != nil {
+			return fmt.Errorf("filepath.Abs(%#v): %v", c.RepositoryOpts.URL, err)
+		}
+
+		c.RepositoryOpts.URL = url
+	}
+
 	serviceIface, err := ctx.LoadModule(c, "ServiceRaw")
 	if err != nil {
 		return fmt.Errorf("error loading module: %v", err)
@@ -70,12 +117,44 @@ func (c *Client) Provision(ctx caddy.Context, log *zap.Logger, repl *caddy.Repla
 		return fmt.Errorf("invalid service configuration")
 	}
 
+	if c.UpdateBackoff == 0 {
+		c.UpdateBackoff = caddy.Duration(10 * time.Second)
+	}
+
+	if c.MaxUpdateBackoff == 0 {
+		c.MaxUpdateBackoff = caddy.Duration(10 * time.Minute)
+	}
+
+	if c.OutputOpts == nil {
+		c.OutputOpts = &caddygit.OutputOpts{}
+	}
+	c.Output = caddygit.NewOutputLog(c.OutputOpts)
+
 	c.CommandsAfter = &caddygit.Commander{
 		OnStart: func(cmd caddygit.Command) {
 			log.Info("running command", zap.String("cmd", cmd.String()))
 		},
 		OnError: func(err error) {
-			log.Warn("cannot run command", zap.Error(err))
+			log.Warn(
+				"cannot run command",
+				zap.Error(err),
+				zap.Strings("output", c.Output.Lines()))
+		},
+		OnOutput: func(cmd caddygit.Command, stream, line string) {
+			log.Debug(
+				"command output",
+				zap.String("line", line),
+				zap.String("path", c.RepositoryOpts.Path),
+				zap.String("cmd", cmd.String()),
+				zap.String("stream", stream))
+		},
+		Supervisor: &caddygit.Supervisor{
+			OnStart: func(cmd caddygit.Command) {
+				log.Info("started service", zap.String("cmd", cmd.String()))
+			},
+			OnExit: func(cmd caddygit.Command, err error) {
+				log.Warn("service exited", zap.String("cmd", cmd.String()), zap.Error(err))
+			},
 		},
 	}
 	for i := range c.RawCommands {
@@ -101,7 +180,29 @@ func (c *Client) Provision(ctx caddy.Context, log *zap.Logger, repl *caddy.Repla
 		return fmt.Errorf("filepath.Abs(%#v): %v", c.RepositoryOpts.Path, err)
 	}
 
-	c.Repo = caddygit.NewRepository(&c.RepositoryOpts)
+	// Commands run in the repository by default.
+	c.CommandsAfter.Dir = c.RepositoryOpts.Path
+
+	c.Repo, err = caddygit.NewRepository(&c.RepositoryOpts)
+	if err != nil {
+		return fmt.Errorf("cannot create repository: %v", err)
+	}
+
+	if c.ReleaseOpts != nil {
+		if c.ReleaseOpts.Dir == "" {
+			// Releases are created next to the repository by default, in
+			// a directory of its own so that the repositories sharing a
+			// parent directory don't share the releases.
+			c.ReleaseOpts.Dir = c.RepositoryOpts.Path + ".releases"
+		}
+
+		c.ReleaseOpts.Dir, err = filepath.Abs(c.ReleaseOpts.Dir)
+		if err != nil {
+			return fmt.Errorf("filepath.Abs(%#v): %v", c.ReleaseOpts.Dir, err)
+		}
+
+		c.Releases = caddygit.NewReleases(c.ReleaseOpts)
+	}
 
 	return nil
 }
@@ -145,15 +246,40 @@ func (c *Client) Validate() error {
 		}
 	}
 
-	u, err := url.Parse(c.RepositoryOpts.URL)
+	ep, err := transport.NewEndpoint(c.RepositoryOpts.URL)
 	if err != nil {
 		return fmt.Errorf("invalid url: %v", err)
 	}
 
-	switch u.Scheme {
-	case "http", "https":
+	switch ep.Protocol {
+	case "http", "https", "ssh", "file":
 	default:
-		return fmt.Errorf("url scheme '%s' not supported", u.Scheme)
+		return fmt.Errorf("url scheme '%s' not supported", ep.Protocol)
+	}
+
+	if ep.Protocol != "ssh" {
+		ssh := c.RepositoryOpts.SSHKey != "" ||
+			c.RepositoryOpts.SSHAgent != "" ||
+			c.RepositoryOpts.KnownHosts != "" ||
+			len(c.RepositoryOpts.HostKeyFingerprints) > 0
+		if ssh {
+			return fmt.Errorf("ssh options provided for non-ssh url")
+		}
+	}
+
+	if c.UpdateBackoff < 0 {
+		return fmt.Errorf("update backoff should be positive")
+	}
+
+	if c.MaxUpdateBackoff < c.UpdateBackoff {
+		return fmt.Errorf("max update backoff should not be less than the update backoff")
+	}
+
+	if c.ReleaseOpts != nil {
+		rel, err := filepath.Rel(c.RepositoryOpts.Path, c.ReleaseOpts.Dir)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("release dir cannot be inside the repository path")
+		}
 	}
 
 	return nil
@@ -162,6 +288,9 @@ func (c *Client) Validate() error {
 // Setup initializes the repository and runs the commands the first time
 // before depending upon the service to update it.
 func (c *Client) Setup(ctx context.Context, log *zap.Logger) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	log.Info("setting up repository", zap.String("path", c.RepositoryOpts.Path))
 	if err := c.Repo.Setup(ctx); err != nil {
 		return fmt.Errorf("cannot setup repository: %v", err)
@@ -172,34 +301,216 @@ func (c *Client) Setup(ctx context.Context, log *zap.Logger) error {
 		return fmt.Errorf("error configuring service: %v", err)
 	}
 
+	if ds, ok := c.Service.(caddygit.DetectingService); ok {
+		ds.SetDetector(c)
+	}
+
 	// When the repo is setup for the first time, always run the commands_after
 	// since they are most probably the setup commands for the repo which might
 	// require building or starting a server.
-	if err := c.CommandsAfter.Run(ctx); err != nil {
-		return fmt.Errorf("cannot run commands: %v", err)
+	if err := c.deploy(ctx, plumbing.ZeroHash); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("cannot run commands: %v", err)
+		}
+
+		// A failed deploy doesn't stop the client since the next update
+		// might fix it.
+		log.Error(
+			"cannot deploy repository",
+			zap.Error(err),
+			zap.String("path", c.RepositoryOpts.Path))
 	}
 
 	return nil
 }
 
 // Update updates the repository and runs the commands if no error is received.
+// If the previous updates failed, it first waits for the backoff to pass.
 func (c *Client) Update(ctx context.Context) error {
+	if err := c.waitBackoff(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.update(ctx)
+	c.recordUpdate(err)
+	return err
+}
+
+// update updates the repository and runs the commands.
+func (c *Client) update(ctx context.Context) error {
 	if err := c.Repo.Update(ctx); err != nil {
 		if err == git.NoErrAlreadyUpToDate {
 			// If the repository is up-to-date, no need to run commands
-			// yet there is no error to update as well
-			return nil
+			// yet there is no error to update as well, unless the commit
+			// failed to deploy.
+			return c.redeploy(ctx)
+		}
+
+		return err
+	}
+
+	return c.deploy(ctx, c.deployed)
+}
+
+// redeploy deploys the checked out commit again if it is not the commit
+// that was last deployed, i.e., the commands failed the last time it was
+// deployed.
+func (c *Client) redeploy(ctx context.Context) error {
+	head, err := c.Repo.Head()
+	if err != nil {
+		return err
+	}
+
+	if head == c.deployed {
+		return nil
+	}
+
+	return c.deploy(ctx, c.deployed)
+}
+
+// Failures returns the number of consecutive updates that have failed.
+func (c *Client) Failures() int {
+	c.failMu.Lock()
+	defer c.failMu.Unlock()
+
+	return c.failures
+}
+
+// Backoff returns the time left before the next update can run.
+func (c *Client) Backoff() time.Duration {
+	c.failMu.Lock()
+	defer c.failMu.Unlock()
+
+	if c.failures == 0 {
+		return 0
+	}
+
+	delay, limit := time.Duration(c.UpdateBackoff), time.Duration(c.MaxUpdateBackoff)
+	for i := 1; i < c.failures && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+
+	return time.Until(c.failedAt.Add(delay))
+}
+
+// recordUpdate tracks the streak of failed updates with the result of an
+// update.
+func (c *Client) recordUpdate(err error) {
+	c.failMu.Lock()
+	defer c.failMu.Unlock()
+
+	if err == nil {
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	c.failedAt = time.Now()
+}
+
+// waitBackoff waits until the backoff after the failed updates, if any,
+// has passed or ctx is canceled.
+func (c *Client) waitBackoff(ctx context.Context) error {
+	backoff := c.Backoff()
+	if backoff <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Changed tells whether the remote repository has changes the repository
+// is not updated to, or the checked out commit failed to deploy. It waits
+// for the update in progress, if any.
+func (c *Client) Changed() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	head, err := c.Repo.Head()
+	if err != nil {
+		return false, err
+	}
+
+	if head != c.deployed {
+		return true, nil
+	}
+
+	return c.Repo.Changed()
+}
+
+// deploy runs the commands on the checked out commit, given the commit
+// deployed before. When using releases, the commit is checked out in a
+// new release directory in which the commands are run. The release is made
+// current only if all the commands succeed, else it is removed.
+func (c *Client) deploy(ctx context.Context, old plumbing.Hash) error {
+	info, err := c.Repo.DeployInfo(old)
+	if err != nil {
+		return err
+	}
+	c.CommandsAfter.Env = info.Env()
+
+	if err := c.Output.Begin(info.NewCommit.String()); err != nil {
+		return fmt.Errorf("cannot create output log: %v", err)
+	}
+	defer c.Output.End()
+	c.CommandsAfter.Output = c.Output.Writer()
+
+	if c.Releases == nil {
+		if err := c.CommandsAfter.Run(ctx); err != nil {
+			return err
+		}
+
+		c.deployed = info.NewCommit
+		return nil
+	}
+
+	dir, err := c.Releases.Create(c.Repo, info.NewCommit)
+	if err != nil {
+		return err
+	}
+
+	c.CommandsAfter.Dir = dir
+	if err := c.CommandsAfter.Run(ctx); err != nil {
+		if rerr := c.Releases.Remove(dir); rerr != nil {
+			return fmt.Errorf("%v (cannot remove release: %v)", err, rerr)
 		}
 
 		return err
 	}
 
-	return c.CommandsAfter.Run(ctx)
+	if err := c.Releases.Activate(dir); err != nil {
+		return fmt.Errorf("cannot activate release: %v", err)
+	}
+
+	c.deployed = info.NewCommit
+	return nil
+}
+
+// Stop gracefully stops the services started by the commands.
+func (c *Client) Stop() {
+	c.CommandsAfter.Supervisor.Stop()
 }
 
 // Start begins the module execution by cloning or opening the repository
-// and starting the service.
+// and starting the service. The services started by commands are stopped
+// when it returns.
 func (c *Client) Start(ctx context.Context, log *zap.Logger) error {
+	defer c.Stop()
+
 	// Setup the repository before starting the service
 	if err := c.Setup(ctx, log); err != nil {
 		return err
@@ -223,10 +534,27 @@ func (c *Client) Start(ctx context.Context, log *zap.Logger) error {
 			}
 
 			if err := c.Update(ctx); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				var sigErr *caddygit.SignatureError
+				if errors.As(err, &sigErr) {
+					log.Error(
+						"refusing to deploy unverified revision",
+						zap.Error(err),
+						zap.String("path", c.RepositoryOpts.Path),
+						zap.Int("failures", c.Failures()),
+						zap.Duration("backoff", c.Backoff()))
+					continue
+				}
+
 				log.Error(
 					"cannot update repository",
 					zap.Error(err),
-					zap.String("path", c.RepositoryOpts.Path))
+					zap.String("path", c.RepositoryOpts.Path),
+					zap.Int("failures", c.Failures()),
+					zap.Duration("backoff", c.Backoff()))
 				continue
 			}
 		}
@@ -235,6 +563,17 @@ func (c *Client) Start(ctx context.Context, log *zap.Logger) error {
 	return nil
 }
 
+// isLocalPath tells if the URL is the plain path of a local repository,
+// i.e., a file URL without the `file://` scheme.
+func isLocalPath(u string) bool {
+	if u == "" || strings.HasPrefix(u, "file://") {
+		return false
+	}
+
+	ep, err := transport.NewEndpoint(u)
+	return err == nil && ep.Protocol == "file"
+}
+
 // isDir tells if root is a directory.
 func isDir(root string) (bool, error) {
 	info, err := os.Stat(root)
@@ -269,14 +608,15 @@ func isDirEmpty(root string) (bool, error) {
 	return false, err
 }
 
-// getRepoNameFromURL extracts the repo name from the HTTP URL of the repo.
+// getRepoNameFromURL extracts the repo name from the HTTP or SSH URL of
+// the repo.
 func getRepoNameFromURL(u string) (string, error) {
-	neturl, err := url.ParseRequestURI(u)
+	ep, err := transport.NewEndpoint(u)
 	if err != nil {
 		return "", err
 	}
 
-	pathSegments := strings.Split(neturl.Path, "/")
+	pathSegments := strings.Split(strings.TrimSuffix(ep.Path, "/"), "/")
 	name := pathSegments[len(pathSegments)-1]
 	return strings.TrimSuffix(name, ".git"), nil
 }
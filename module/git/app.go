@@ -9,6 +9,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/vrongmeal/caddygit/module"
+	"github.com/vrongmeal/caddygit/services/webhook"
 )
 
 func init() {
@@ -19,6 +20,10 @@ func init() {
 type App struct {
 	Clients []module.Client `json:"clients,omitempty"`
 
+	// WebhookServer, if set, is the server shared by the webhook services
+	// of clients that have neither a port nor a listen address of their own.
+	WebhookServer *webhook.SharedServer `json:"webhook_server,omitempty"`
+
 	logger *zap.Logger
 	wg     sync.WaitGroup
 	ctx    context.Context
@@ -43,6 +48,10 @@ func (a *App) Provision(ctx caddy.Context) error {
 		return err
 	}
 
+	if err := a.provisionWebhookServer(ctx); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -52,6 +61,12 @@ func (a *App) Validate() error {
 		return err
 	}
 
+	if a.WebhookServer != nil {
+		if err := a.WebhookServer.Validate(); err != nil {
+			return fmt.Errorf("webhook server: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -59,6 +74,17 @@ func (a *App) Validate() error {
 func (a *App) Start() error {
 	a.logger.Info("starting module")
 
+	if a.WebhookServer != nil {
+		a.wg.Add(1)
+		go func(ctx context.Context, log *zap.Logger) {
+			defer a.wg.Done()
+
+			if err := a.WebhookServer.Serve(ctx); err != nil {
+				log.Error(fmt.Sprintf("webhook server: %v", err))
+			}
+		}(a.ctx, a.logger)
+	}
+
 	if err := a.startClients(); err != nil {
 		return err
 	}
@@ -102,6 +128,26 @@ func (a *App) provisionClients(ctx caddy.Context, repl *caddy.Replacer) error {
 	return nil
 }
 
+// provisionWebhookServer sets up the shared webhook server and makes the
+// services of clients share it.
+func (a *App) provisionWebhookServer(ctx caddy.Context) error {
+	if a.WebhookServer == nil {
+		return nil
+	}
+
+	if err := a.WebhookServer.Provision(ctx); err != nil {
+		return fmt.Errorf("webhook server: %v", err)
+	}
+
+	for i := 0; i < len(a.Clients); i++ {
+		if sharer, ok := a.Clients[i].Service.(webhook.Sharer); ok {
+			sharer.Share(a.WebhookServer)
+		}
+	}
+
+	return nil
+}
+
 // validateClients ensures that the clients have correct configuration.
 func (a *App) validateClients() error {
 	for i := 0; i < len(a.Clients); i++ {
@@ -0,0 +1,193 @@
+package git
+
+import (
+	"encoding/json"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+
+	"github.com/vrongmeal/caddygit/module"
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	httpcaddyfile.RegisterGlobalOption("git", parseGlobalOption)
+	httpcaddyfile.RegisterHandlerDirective("git", parseHandlerDirective)
+}
+
+// parseGlobalOption sets up the git app from the `git` global option. The
+// clients of multiple `git` global options are merged into a single app.
+func parseGlobalOption(d *caddyfile.Dispenser, existingVal interface{}) (interface{}, error) {
+	app := new(App)
+
+	if existing, ok := existingVal.(httpcaddyfile.App); ok {
+		if err := json.Unmarshal(existing.Value, app); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := app.UnmarshalCaddyfile(d); err != nil {
+		return nil, err
+	}
+
+	return httpcaddyfile.App{
+		Name:  "git",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+// parseHandlerDirective sets up the handler from the `git` directive.
+func parseHandlerDirective(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	handler := new(Handler)
+	err := handler.UnmarshalCaddyfile(h.Dispenser)
+	return handler, err
+}
+
+// UnmarshalCaddyfile sets up the app from Caddyfile tokens. Syntax:
+//
+// 	git {
+// 		client         { ... }
+// 		webhook_server [<port>] { ... }
+// 	}
+//
+// The `client` sub-directive can be repeated to create multiple clients.
+func (a *App) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "client":
+				a.Clients = append(a.Clients, module.Client{})
+				client := &a.Clients[len(a.Clients)-1]
+				if err := client.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+					return err
+				}
+
+			case "webhook_server":
+				a.WebhookServer = new(webhook.SharedServer)
+				if err := a.WebhookServer.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+					return err
+				}
+
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+// 	git [<matcher>] {
+// 		repo                   <url> [<path>] { ... }
+// 		command                <args...> { ... }
+// 		release                [<dir>] { ... }
+// 		output                 { ... }
+// 		update_backoff         <backoff> [<max_backoff>]
+// 		hook_secret            <secret>
+// 		hook_replay_window     <duration>
+// 		hook                   <type> { ... }
+// 		allowed_sources        <ranges...>
+// 		allowed_sources_preset <provider> <file>
+// 		trusted_proxies        <ranges...>
+// 	}
+//
+// The `command` sub-directive can be repeated to run multiple commands.
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			if err := h.unmarshalCaddyfileOption(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfileOption parses the sub-directive of handler the
+// dispenser is at.
+func (h *Handler) unmarshalCaddyfileOption(d *caddyfile.Dispenser) error {
+	switch d.Val() {
+	case "repo":
+		return module.UnmarshalRepositoryOpts(d, &h.Repository)
+
+	case "command":
+		cmd, err := module.UnmarshalCommand(d)
+		if err != nil {
+			return err
+		}
+		h.Commands = append(h.Commands, cmd)
+
+	case "release":
+		opts, err := module.UnmarshalReleaseOpts(d)
+		if err != nil {
+			return err
+		}
+		h.Release = opts
+
+	case "output":
+		opts, err := module.UnmarshalOutputOpts(d)
+		if err != nil {
+			return err
+		}
+		h.Output = opts
+
+	case "hook_secret":
+		if !d.AllArgs(&h.Secret) {
+			return d.ArgErr()
+		}
+
+	case "hook_replay_window":
+		var window string
+		if !d.AllArgs(&window) {
+			return d.ArgErr()
+		}
+
+		dur, err := caddy.ParseDuration(window)
+		if err != nil {
+			return d.Errf("invalid duration '%s': %v", window, err)
+		}
+		h.ReplayWindow = caddy.Duration(dur)
+
+	case "update_backoff":
+		return module.UnmarshalUpdateBackoff(d, &h.UpdateBackoff, &h.MaxUpdateBackoff)
+
+	case "hook":
+		raw, err := webhook.UnmarshalHookRaw(d)
+		if err != nil {
+			return err
+		}
+		h.HookRaw = raw
+
+	default:
+		handled, err := h.SourcesOpts.UnmarshalCaddyfileOption(d)
+		if err != nil {
+			return err
+		}
+
+		if !handled {
+			return d.Errf("unrecognized subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ caddyfile.Unmarshaler = (*App)(nil)
+	_ caddyfile.Unmarshaler = (*Handler)(nil)
+)
@@ -26,9 +26,17 @@ func init() {
 type Handler struct {
 	Repository caddygit.RepositoryOpts `json:"repo,omitempty"`
 	Commands   []caddygit.Command      `json:"commands_after,omitempty"`
+	Release    *caddygit.ReleaseOpts   `json:"release,omitempty"`
+	Output     *caddygit.OutputOpts    `json:"output,omitempty"`
 
-	Secret  string          `json:"hook_secret,omitempty"`
-	HookRaw json.RawMessage `json:"hook" caddy:"namespace=git.services.webhook inline_key=type"`
+	UpdateBackoff    caddy.Duration `json:"update_backoff,omitempty"`
+	MaxUpdateBackoff caddy.Duration `json:"max_update_backoff,omitempty"`
+
+	Secret       string          `json:"hook_secret,omitempty"`
+	ReplayWindow caddy.Duration  `json:"hook_replay_window,omitempty"`
+	HookRaw      json.RawMessage `json:"hook" caddy:"namespace=git.services.webhook inline_key=type"`
+
+	webhook.SourcesOpts
 
 	client *module.Client
 	whs    *webhook.Service
@@ -36,6 +44,12 @@ type Handler struct {
 	ctx    context.Context
 	once   sync.Once
 	setup  bool
+
+	// updating is set while an update runs and pending when another
+	// update is requested meanwhile.
+	mu       sync.Mutex
+	updating bool
+	pending  bool
 }
 
 // CaddyModule returns the module information.
@@ -65,9 +79,13 @@ func (h *Handler) Provision(ctx caddy.Context) error {
 	}
 
 	service := map[string]interface{}{
-		"type":   "webhook",
-		"secret": h.Secret,
-		"hook":   h.HookRaw,
+		"type":                   "webhook",
+		"secret":                 h.Secret,
+		"hook":                   h.HookRaw,
+		"replay_window":          h.ReplayWindow,
+		"allowed_sources":        h.AllowedSources,
+		"allowed_sources_preset": h.SourcesPreset,
+		"trusted_proxies":        h.TrustedProxies,
 	}
 	rawService, err := json.Marshal(service)
 	if err != nil {
@@ -78,6 +96,11 @@ func (h *Handler) Provision(ctx caddy.Context) error {
 		RepositoryOpts: h.Repository,
 		RawCommands:    h.Commands,
 		ServiceRaw:     rawService,
+		ReleaseOpts:    h.Release,
+		OutputOpts:     h.Output,
+
+		UpdateBackoff:    h.UpdateBackoff,
+		MaxUpdateBackoff: h.MaxUpdateBackoff,
 	}
 
 	err = h.client.Provision(ctx, h.log, repl)
@@ -133,20 +156,61 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyht
 	}
 
 	if err := whs.ServeHTTP(w, r, next); err != nil {
+		if err == webhook.ErrDuplicateDelivery || err == webhook.ErrNoUpdate {
+			return nil
+		}
+
 		return err
 	}
 
-	go func(handler *Handler) {
-		handler.log.Info("updating repository", zap.String("path", handler.client.RepositoryOpts.Path))
+	h.update()
+	return nil
+}
 
-		if err := handler.client.Update(handler.ctx); err != nil {
-			handler.log.Error(
-				"cannot update repository",
-				zap.Error(err),
-				zap.String("path", handler.client.RepositoryOpts.Path))
-			return
+// update updates the repository in the background. Updates requested while
+// one is running are coalesced into a single update that follows it.
+func (h *Handler) update() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.updating {
+		h.pending = true
+		return
+	}
+	h.updating = true
+
+	go func(handler *Handler) {
+		for {
+			handler.log.Info("updating repository", zap.String("path", handler.client.RepositoryOpts.Path))
+
+			// Update waits for the backoff if the previous updates failed,
+			// so that failing updates are not retried on every event.
+			if err := handler.client.Update(handler.ctx); err != nil {
+				handler.log.Error(
+					"cannot update repository",
+					zap.Error(err),
+					zap.String("path", handler.client.RepositoryOpts.Path),
+					zap.Int("failures", handler.client.Failures()),
+					zap.Duration("backoff", handler.client.Backoff()))
+			}
+
+			handler.mu.Lock()
+			if !handler.pending {
+				handler.updating = false
+				handler.mu.Unlock()
+				return
+			}
+			handler.pending = false
+			handler.mu.Unlock()
 		}
 	}(h)
+}
+
+// Cleanup stops the services started by the client commands.
+func (h *Handler) Cleanup() error {
+	if h.client != nil {
+		h.client.Stop()
+	}
 
 	return nil
 }
@@ -156,5 +220,6 @@ var (
 	_ caddy.Module                = (*Handler)(nil)
 	_ caddy.Provisioner           = (*Handler)(nil)
 	_ caddy.Validator             = (*Handler)(nil)
+	_ caddy.CleanerUpper          = (*Handler)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
 )
@@ -2,8 +2,15 @@ package git
 
 import (
 	// Submodules for the git app module registered here
+	_ "github.com/vrongmeal/caddygit/services/fswatch"
+	_ "github.com/vrongmeal/caddygit/services/multi"
 	_ "github.com/vrongmeal/caddygit/services/poll"
 	_ "github.com/vrongmeal/caddygit/services/webhook"
+	_ "github.com/vrongmeal/caddygit/services/webhook/azure"
+	_ "github.com/vrongmeal/caddygit/services/webhook/bitbucket"
 	_ "github.com/vrongmeal/caddygit/services/webhook/generic"
+	_ "github.com/vrongmeal/caddygit/services/webhook/gitea"
 	_ "github.com/vrongmeal/caddygit/services/webhook/github"
+	_ "github.com/vrongmeal/caddygit/services/webhook/gitlab"
+	_ "github.com/vrongmeal/caddygit/services/webhook/sns"
 )
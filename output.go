@@ -0,0 +1,243 @@
+package caddygit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for output logs.
+const (
+	DefaultOutputLines = 100
+	DefaultOutputKeep  = 10
+
+	// maxLineLength is the length after which a line without newline is
+	// broken into multiple lines.
+	maxLineLength = 64 * 1024
+)
+
+// Output streams of commands.
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// OutputOpts are the options for logging the output of commands.
+type OutputOpts struct {
+	// Lines is the number of last lines of output kept in memory. Defaults
+	// to 100.
+	Lines int `json:"lines,omitempty"`
+
+	// Dir to write a log file for every deploy in. If empty, no log files
+	// are written.
+	Dir string `json:"log_dir,omitempty"`
+
+	// Keep is the number of log files to keep. Defaults to 10.
+	Keep int `json:"keep_logs,omitempty"`
+}
+
+// OutputLog keeps the last lines of output of the commands of a deploy in
+// memory and optionally writes the output of every deploy in a log file.
+type OutputLog struct {
+	dir      string
+	keep     int
+	maxLines int
+
+	mu    sync.Mutex
+	lines []string
+	file  *os.File
+
+	// gen is incremented by every Begin so that the output of the commands
+	// of a deploy is not logged in the next one. open tells whether the
+	// deploy of gen has not ended yet.
+	gen  uint64
+	open bool
+}
+
+// NewOutputLog creates an output log with given options.
+func NewOutputLog(opts *OutputOpts) *OutputLog {
+	o := &OutputLog{
+		dir:      opts.Dir,
+		keep:     opts.Keep,
+		maxLines: opts.Lines,
+	}
+
+	if o.keep <= 0 {
+		o.keep = DefaultOutputKeep
+	}
+
+	if o.maxLines <= 0 {
+		o.maxLines = DefaultOutputLines
+	}
+
+	return o
+}
+
+// Begin starts logging the output of a new deploy with given name. The
+// lines of previous deploy are discarded.
+func (o *OutputLog) Begin(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.gen++
+	o.open = true
+	o.lines = nil
+	o.closeFile()
+
+	if o.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return err
+	}
+
+	// Log files are named such that sorting them by name sorts them by time.
+	fileName := fmt.Sprintf("%d-%s.log", time.Now().UnixNano(), name)
+	f, err := os.OpenFile(
+		filepath.Join(o.dir, filepath.Base(fileName)),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+		0600,
+	)
+	if err != nil {
+		return err
+	}
+	o.file = f
+
+	return o.prune()
+}
+
+// End stops logging the output of current deploy. The lines are still
+// kept in memory.
+func (o *OutputLog) End() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.open = false
+	o.closeFile()
+}
+
+// Writer returns the func that logs the lines of output of the commands of
+// current deploy. The lines written after the deploy has ended, e.g., by
+// the async commands and services it started, are dropped.
+func (o *OutputLog) Writer() func(cmd Command, stream, line string) {
+	o.mu.Lock()
+	gen := o.gen
+	o.mu.Unlock()
+
+	return func(cmd Command, stream, line string) {
+		o.write(gen, cmd, stream, line)
+	}
+}
+
+// write logs the line of output from stream of the command if the deploy
+// of gen is still being logged.
+func (o *OutputLog) write(gen uint64, cmd Command, stream, line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.open || gen != o.gen {
+		return
+	}
+
+	o.lines = append(o.lines, line)
+	if len(o.lines) > o.maxLines {
+		o.lines = o.lines[len(o.lines)-o.maxLines:]
+	}
+
+	if o.file != nil {
+		_, _ = fmt.Fprintf(o.file, "%s [%s] %s: %s\n",
+			time.Now().Format(time.RFC3339), stream, cmd.String(), line)
+	}
+}
+
+// Lines returns the last lines of output of the current deploy.
+func (o *OutputLog) Lines() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	lines := make([]string, len(o.lines))
+	copy(lines, o.lines)
+	return lines
+}
+
+// closeFile closes the log file if open.
+func (o *OutputLog) closeFile() {
+	if o.file != nil {
+		_ = o.file.Close()
+		o.file = nil
+	}
+}
+
+// prune removes all but the latest `keep` log files.
+func (o *OutputLog) prune() error {
+	infos, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".log") {
+			names = append(names, info.Name())
+		}
+	}
+
+	sort.Strings(names)
+	for len(names) > o.keep {
+		if err := os.Remove(filepath.Join(o.dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+
+	return nil
+}
+
+// lineWriter is an io.Writer that calls fn for every line written.
+type lineWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	fn  func(string)
+}
+
+// Write implements the io.Writer interface.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+
+		w.fn(strings.TrimSuffix(string(w.buf[:idx]), "\r"))
+		w.buf = w.buf[idx+1:]
+	}
+
+	if len(w.buf) >= maxLineLength {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+
+	return len(p), nil
+}
+
+// Flush calls fn with the remaining output which doesn't end in newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,185 @@
+package caddygit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Defaults for releases.
+const (
+	DefaultReleasesKeep = 5
+
+	releasesDir    = "releases"
+	currentSymlink = "current"
+)
+
+// ReleaseOpts are the options for deploying the repository as releases.
+type ReleaseOpts struct {
+	// Dir in which the `releases` directory and the `current` symlink are
+	// created. Defaults to `<path>.releases` next to the repository path.
+	Dir string `json:"dir,omitempty"`
+
+	// Keep is the number of releases to keep. Defaults to 5.
+	Keep int `json:"keep,omitempty"`
+}
+
+// Releases manages the release directories of a repository. Every commit
+// is checked out in `<Dir>/releases/<hash>` and the `<Dir>/current` symlink
+// points to the release that is live. A commit that is deployed again while
+// it's live is checked out in `<Dir>/releases/<hash>-<time>` instead, so
+// that the live release is never modified.
+type Releases struct {
+	dir  string
+	keep int
+}
+
+// NewReleases creates the releases with given options.
+func NewReleases(opts *ReleaseOpts) *Releases {
+	rs := &Releases{
+		dir:  opts.Dir,
+		keep: opts.Keep,
+	}
+
+	if rs.keep <= 0 {
+		rs.keep = DefaultReleasesKeep
+	}
+
+	return rs
+}
+
+// Path returns the directory of release for the given commit hash.
+func (rs *Releases) Path(hash plumbing.Hash) string {
+	return filepath.Join(rs.dir, releasesDir, hash.String())
+}
+
+// Current returns the hash of the release `current` points to. A zero hash
+// is returned if there is no current release.
+func (rs *Releases) Current() (plumbing.Hash, error) {
+	name, err := rs.current()
+	if err != nil || name == "" {
+		return plumbing.ZeroHash, err
+	}
+
+	return plumbing.NewHash(strings.SplitN(name, "-", 2)[0]), nil
+}
+
+// current returns the name of the release directory `current` points to.
+// An empty name is returned if there is no current release.
+func (rs *Releases) current() (string, error) {
+	target, err := os.Readlink(filepath.Join(rs.dir, currentSymlink))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return filepath.Base(target), nil
+}
+
+// Create checks out the commit with given hash from the repository into a
+// new release directory and returns the directory. If the commit is the
+// current release, it is checked out into another directory.
+func (rs *Releases) Create(r *Repository, hash plumbing.Hash) (string, error) {
+	current, err := rs.current()
+	if err != nil {
+		return "", err
+	}
+
+	dir := rs.Path(hash)
+	if filepath.Base(dir) == current {
+		dir += "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+
+	// Remove leftovers of a release that was never made current.
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+
+	if err := r.Export(hash, dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("cannot export release: %v", err)
+	}
+
+	return dir, nil
+}
+
+// Activate atomically switches the `current` symlink to the release in dir
+// and prunes the old releases.
+func (rs *Releases) Activate(dir string) error {
+	name := filepath.Base(dir)
+	current := filepath.Join(rs.dir, currentSymlink)
+	tmp := current + ".tmp"
+
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	// The symlink is relative so that the directory can be moved around.
+	if err := os.Symlink(filepath.Join(releasesDir, name), tmp); err != nil {
+		return err
+	}
+
+	// Renaming is atomic, hence `current` always points to a release.
+	if err := os.Rename(tmp, current); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return rs.prune(name)
+}
+
+// Remove deletes the release in dir unless it is current.
+func (rs *Releases) Remove(dir string) error {
+	current, err := rs.current()
+	if err != nil {
+		return err
+	}
+
+	if filepath.Base(dir) == current {
+		return nil
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// prune removes all but the latest `keep` releases. The current release
+// is never removed.
+func (rs *Releases) prune(current string) error {
+	infos, err := ioutil.ReadDir(filepath.Join(rs.dir, releasesDir))
+	if err != nil {
+		return err
+	}
+
+	// Newest releases first.
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	kept := 1
+	for _, info := range infos {
+		if !info.IsDir() || info.Name() == current {
+			continue
+		}
+
+		if kept < rs.keep {
+			kept++
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(rs.dir, releasesDir, info.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
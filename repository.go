@@ -4,14 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/memory"
 )
 
@@ -25,7 +28,8 @@ var errNoTag = errors.New("no tag found")
 
 // RepositoryOpts are the options for creating a repository.
 type RepositoryOpts struct {
-	// URL (HTTP only) of the git repository.
+	// URL (HTTP or SSH) of the git repository. SSH URLs can either be of the
+	// form `ssh://user@host/path` or scp-style `user@host:path`.
 	URL string `json:"url,omitempty"`
 
 	// Path to clone the repository in. If path specified exists and is a git
@@ -43,6 +47,35 @@ type RepositoryOpts struct {
 	Username string `json:"auth_user,omitempty"`
 	Password string `json:"auth_secret,omitempty"`
 
+	// SSHKey is the path to private key file used to authenticate over SSH.
+	// SSHKeyPassphrase is used to decrypt the key if it is encrypted.
+	SSHKey           string `json:"ssh_key,omitempty"`
+	SSHKeyPassphrase string `json:"ssh_key_passphrase,omitempty"`
+
+	// SSHAgent is the path to ssh-agent socket used to authenticate over SSH
+	// when no SSHKey is provided.
+	SSHAgent string `json:"ssh_agent,omitempty"`
+
+	// KnownHosts is the path to known_hosts file used to verify the SSH host.
+	// If empty, the default known_hosts files are used.
+	KnownHosts string `json:"known_hosts,omitempty"`
+
+	// HostKeyFingerprints pins the SSH host keys (for eg. "SHA256:...") that
+	// are trusted. If set, KnownHosts is ignored.
+	HostKeyFingerprints []string `json:"host_key_fingerprints,omitempty"`
+
+	// TrustedPGPKeys are the paths to files with armored OpenPGP public keys
+	// and TrustedSSHKeys are the SSH public keys (authorized_keys format)
+	// trusted to sign commits and tags. If any key is given, the worktree is
+	// only moved to a commit (or an annotated tag) signed by a trusted key.
+	TrustedPGPKeys []string `json:"trusted_pgp_keys,omitempty"`
+	TrustedSSHKeys []string `json:"trusted_ssh_keys,omitempty"`
+
+	// TagConstraint, if set, tracks the highest tag of the repository which
+	// satisfies the semantic version constraint (for eg. `^2.3`,
+	// `>=1.0 <2.0` or `!prerelease`). Branch is ignored in this case.
+	TagConstraint string `json:"tag_constraint,omitempty"`
+
 	// SingleBranch specifies whether to clone only the specified branch.
 	SingleBranch bool `json:"single_branch,omitempty"`
 
@@ -55,9 +88,17 @@ type RepositoryInfo struct {
 	URL  string
 	Path string
 
+	// LatestTag is set when tracking the highest tag that satisfies
+	// TagConstraint.
 	LatestTag     bool
 	ReferenceName plumbing.ReferenceName
 
+	// CurrentTag returns the tag checked out when tracking tags with
+	// TagConstraint. It's resolved on every call since the tag changes with
+	// every update.
+	CurrentTag    func() plumbing.ReferenceName
+	TagConstraint *TagConstraint
+
 	SingleBranch bool
 	Depth        int
 }
@@ -67,41 +108,51 @@ type RepositoryInfo struct {
 type Repository struct {
 	repo *git.Repository
 
-	url            string
-	path           string
-	branch         string
-	fetchLatestTag bool
-	refName        plumbing.ReferenceName
-	auth           transport.AuthMethod
-	singleBranch   bool
-	depth          int
+	url           string
+	path          string
+	branch        string
+	refName       plumbing.ReferenceName
+	tagConstraint *TagConstraint
+	auth          transport.AuthMethod
+	verifier      *verifier
+	singleBranch  bool
+	depth         int
+
+	// tagMu guards refName when tracking tags, since it changes with the
+	// updates while the services read it.
+	tagMu sync.RWMutex
 }
 
 // NewRepository creates a new repository with given options.
-func NewRepository(opts *RepositoryOpts) *Repository {
+func NewRepository(opts *RepositoryOpts) (*Repository, error) {
+	auth, err := newAuthMethod(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := newVerifier(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &Repository{
 		url:          opts.URL,
 		path:         opts.Path,
 		branch:       opts.Branch,
+		auth:         auth,
+		verifier:     v,
 		singleBranch: opts.SingleBranch,
 		depth:        opts.Depth,
 	}
 
-	if opts.Username == "" && opts.Password == "" {
-		r.auth = nil
-	} else {
-		username := "caddy"
-		if opts.Username != "" {
-			username = opts.Username
-		}
-
-		r.auth = &http.BasicAuth{
-			Username: username,
-			Password: opts.Password,
+	if opts.TagConstraint != "" {
+		r.tagConstraint, err = NewTagConstraint(opts.TagConstraint)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return r
+	return r, nil
 }
 
 // Setup initializes the git repository by either cloning or opening it.
@@ -139,6 +190,11 @@ func (r *Repository) Setup(ctx context.Context) error {
 			return err
 		}
 
+		err = r.verify(r.refName)
+		if err != nil {
+			return err
+		}
+
 		err = r.checkout(r.refName)
 		if err != nil {
 			return err
@@ -157,27 +213,167 @@ func (r *Repository) Setup(ctx context.Context) error {
 		SingleBranch:  r.singleBranch,
 		Depth:         r.depth,
 		Tags:          git.AllTags,
+		// The worktree is checked out only after the reference is verified.
+		NoCheckout: r.verifier != nil,
 	})
 	if err != nil {
 		return err
 	}
 
+	if r.verifier != nil {
+		if err := r.verify(r.refName); err != nil {
+			return err
+		}
+
+		return r.checkout(r.refName)
+	}
+
 	return nil
 }
 
 // Info returns information about the repository.
 func (r *Repository) Info() RepositoryInfo {
-	return RepositoryInfo{
+	info := RepositoryInfo{
 		URL:           r.url,
 		Path:          r.path,
-		LatestTag:     r.fetchLatestTag,
+		LatestTag:     r.tagConstraint != nil,
 		ReferenceName: r.refName,
+		TagConstraint: r.tagConstraint,
 		SingleBranch:  r.singleBranch,
 		Depth:         r.depth,
 	}
+
+	if r.tagConstraint != nil {
+		info.CurrentTag = r.currentTag
+	}
+
+	return info
+}
+
+// currentTag returns the tag checked out when tracking tags.
+func (r *Repository) currentTag() plumbing.ReferenceName {
+	r.tagMu.RLock()
+	defer r.tagMu.RUnlock()
+
+	return r.refName
+}
+
+// setTag sets the tag checked out when tracking tags.
+func (r *Repository) setTag(tag plumbing.ReferenceName) {
+	r.tagMu.Lock()
+	defer r.tagMu.Unlock()
+
+	r.refName = tag
+}
+
+// AcceptsTag tells if a new tag (short name) can change the tree of the
+// repository. When tracking tags with a constraint, the tag should satisfy
+// the constraint and be higher than the tag checked out.
+func (ri *RepositoryInfo) AcceptsTag(tag string) bool {
+	if ri.TagConstraint == nil {
+		return true
+	}
+
+	v, ok := ri.TagConstraint.Match(tag)
+	if !ok {
+		return false
+	}
+
+	if ri.CurrentTag == nil {
+		return true
+	}
+
+	if current, ok := ri.TagConstraint.Match(ri.CurrentTag().Short()); ok {
+		return v.GreaterThan(current)
+	}
+
+	return true
+}
+
+// Head returns the hash of the commit checked out in the worktree.
+func (r *Repository) Head() (plumbing.Hash, error) {
+	head, err := r.repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return head.Hash(), nil
+}
+
+// Export writes the files of the commit with given hash into dir.
+func (r *Repository) Export(hash plumbing.Hash, dir string) error {
+	commit, err := r.repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+
+	files, err := commit.Files()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return files.ForEach(func(f *object.File) error {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(target, path)
+		}
+
+		return exportFile(f, path)
+	})
+}
+
+// exportFile writes the contents of f into the given path.
+func exportFile(f *object.File, path string) error {
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	src, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer src.Close() // nolint:errcheck
+
+	dst, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	return dst.Close()
 }
 
 func (r *Repository) setRef(ctx context.Context) error {
+	if r.tagConstraint != nil {
+		// When tracking tags with a constraint, reference is the highest tag
+		// that satisfies the constraint.
+		tag, err := r.latestRemoteTag()
+		if err != nil {
+			return err
+		}
+
+		r.setTag(tag)
+		return nil
+	}
+
 	// First we fetch the references from remote and then compare it to
 	// both the branch reference name and tag reference name. The reference
 	// name that matches first is selected (preferably branch).
@@ -227,15 +423,15 @@ func (r *Repository) setRef(ctx context.Context) error {
 
 // Update pulls/fetches updates from the remote repository into current worktree.
 func (r *Repository) Update(ctx context.Context) error {
-	if r.fetchLatestTag {
-		lt, err := r.getLatestTag(ctx)
-		if err != nil {
-			return err
-		}
-		return r.checkout(lt)
+	if r.tagConstraint != nil {
+		return r.updateTag(ctx)
 	}
 
 	if r.refName.IsBranch() {
+		if r.verifier != nil {
+			return r.pullVerified(ctx)
+		}
+
 		return r.pull(ctx)
 	}
 
@@ -243,6 +439,98 @@ func (r *Repository) Update(ctx context.Context) error {
 	return git.NoErrAlreadyUpToDate
 }
 
+// updateTag checks out the highest tag satisfying the constraint if it's
+// not already checked out.
+func (r *Repository) updateTag(ctx context.Context) error {
+	tag, err := r.latestRemoteTag()
+	if err != nil {
+		return err
+	}
+
+	if tag == r.refName {
+		return git.NoErrAlreadyUpToDate
+	}
+
+	if err := r.fetch(ctx); err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	if err := r.verify(tag); err != nil {
+		return err
+	}
+
+	if err := r.checkout(tag); err != nil {
+		return err
+	}
+
+	r.setTag(tag)
+	return nil
+}
+
+// Changed tells whether the remote has changes that Update would check out.
+// Only the references of remote are listed (like `git ls-remote`) and
+// compared with the checked out commit or the resolved tag, so it's much
+// cheaper than an update.
+func (r *Repository) Changed() (bool, error) {
+	if r.tagConstraint != nil {
+		tag, err := r.latestRemoteTag()
+		if err != nil {
+			return false, err
+		}
+
+		return tag != r.refName, nil
+	}
+
+	// A static tag is never updated.
+	if !r.refName.IsBranch() {
+		return false, nil
+	}
+
+	refs, err := r.listRemote()
+	if err != nil {
+		return false, err
+	}
+
+	head, err := r.repo.Head()
+	if err != nil {
+		return false, err
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == r.refName {
+			return ref.Hash() != head.Hash(), nil
+		}
+	}
+
+	return false, fmt.Errorf("reference with name '%s' not found", r.refName.Short())
+}
+
+// listRemote lists the references of remote without fetching them.
+func (r *Repository) listRemote() ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemote,
+		URLs: []string{r.url},
+	})
+
+	return remote.List(&git.ListOptions{Auth: r.auth})
+}
+
+// latestRemoteTag lists the tags of remote and returns the highest one that
+// satisfies the tag constraint.
+func (r *Repository) latestRemoteTag() (plumbing.ReferenceName, error) {
+	refs, err := r.listRemote()
+	if err != nil {
+		return plumbing.ReferenceName(""), err
+	}
+
+	tag, err := r.tagConstraint.Latest(refs)
+	if err != nil {
+		return tag, fmt.Errorf("%v matching '%s'", err, r.tagConstraint)
+	}
+
+	return tag, nil
+}
+
 func (r *Repository) pull(ctx context.Context) error {
 	wtree, err := r.repo.Worktree()
 	if err != nil {
@@ -262,87 +550,81 @@ func (r *Repository) pull(ctx context.Context) error {
 	return nil
 }
 
-func (r *Repository) fetch(ctx context.Context) error {
-	if err := r.repo.FetchContext(ctx, &git.FetchOptions{
-		RemoteName: DefaultRemote,
-		Depth:      r.depth,
-		Auth:       r.auth,
-		Tags:       git.AllTags,
-	}); err != nil {
+// pullVerified fetches the branch from remote and moves the worktree to it
+// only if the commit is signed by a trusted key. Unlike pull, the branch is
+// reset to the remote branch.
+func (r *Repository) pullVerified(ctx context.Context) error {
+	if err := r.fetch(ctx); err != nil && err != git.NoErrAlreadyUpToDate {
 		return err
 	}
 
-	return nil
-}
-
-func (r *Repository) checkout(ref plumbing.ReferenceName) error {
-	wtree, err := r.repo.Worktree()
+	remoteRef, err := r.repo.Reference(
+		plumbing.NewRemoteReferenceName(DefaultRemote, r.refName.Short()), true)
 	if err != nil {
 		return err
 	}
 
-	if err := wtree.Checkout(&git.CheckoutOptions{Branch: ref}); err != nil {
+	head, err := r.repo.Head()
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (r *Repository) getLatestTag(ctx context.Context) (plumbing.ReferenceName, error) {
-	nilReferenceName := plumbing.ReferenceName("")
-
-	if err := r.fetch(ctx); err != nil {
-		return nilReferenceName, err
+	if head.Hash() == remoteRef.Hash() {
+		return git.NoErrAlreadyUpToDate
 	}
 
-	if err := r.checkout(r.refName); err != nil {
-		return nilReferenceName, err
+	if err := r.verifier.Verify(r.repo, remoteRef.Hash()); err != nil {
+		return err
 	}
 
-	tagsMap := make(map[plumbing.Hash]*plumbing.Reference)
-
-	tags, err := r.repo.Tags()
+	wtree, err := r.repo.Worktree()
 	if err != nil {
-		return nilReferenceName, err
+		return err
 	}
 
-	err = tags.ForEach(func(ref *plumbing.Reference) error {
-		tagsMap[ref.Hash()] = ref
-		return nil
+	return wtree.Reset(&git.ResetOptions{
+		Commit: remoteRef.Hash(),
+		Mode:   git.HardReset,
 	})
-	if err != nil {
-		return nilReferenceName, err
-	}
+}
 
-	head, err := r.repo.Head()
-	if err != nil {
-		return nilReferenceName, err
+// verify verifies the signature of object the reference points to. It's a
+// no-op if there are no trusted keys.
+func (r *Repository) verify(name plumbing.ReferenceName) error {
+	if r.verifier == nil {
+		return nil
 	}
 
-	commits, err := r.repo.Log(&git.LogOptions{
-		From:  head.Hash(),
-		Order: git.LogOrderCommitterTime,
-	})
+	ref, err := r.repo.Reference(name, true)
 	if err != nil {
-		return nilReferenceName, err
+		return err
 	}
 
-	var tag *plumbing.Reference
-	if err := commits.ForEach(func(commit *object.Commit) error {
-		if t, ok := tagsMap[commit.Hash]; ok {
-			tag = t
-		}
-		if tag != nil {
-			return storer.ErrStop
-		}
-		return nil
+	return r.verifier.Verify(r.repo, ref.Hash())
+}
+
+func (r *Repository) fetch(ctx context.Context) error {
+	if err := r.repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: DefaultRemote,
+		Depth:      r.depth,
+		Auth:       r.auth,
+		Tags:       git.AllTags,
 	}); err != nil {
-		return nilReferenceName, err
+		return err
 	}
 
-	if tag == nil {
-		return nilReferenceName, errNoTag
+	return nil
+}
+
+func (r *Repository) checkout(ref plumbing.ReferenceName) error {
+	wtree, err := r.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	if err := wtree.Checkout(&git.CheckoutOptions{Branch: ref}); err != nil {
+		return err
 	}
 
-	return tag.Name(), nil
+	return nil
 }
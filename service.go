@@ -22,3 +22,17 @@ type Service interface {
 	// Start receives the time when the service needs to update.
 	Start(context.Context) <-chan error
 }
+
+// ChangeDetector tells whether the remote repository has changes that the
+// repository is not updated to.
+type ChangeDetector interface {
+	Changed() (bool, error)
+}
+
+// DetectingService is a service that only ticks when the detector finds
+// changes. The detector is set before the service is started.
+type DetectingService interface {
+	Service
+
+	SetDetector(ChangeDetector)
+}
@@ -0,0 +1,341 @@
+package fswatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/vrongmeal/caddygit"
+)
+
+// settleDelay is the time to wait for the writes of git to settle after an
+// event before reading the references. A push writes many files.
+const settleDelay = 500 * time.Millisecond
+
+func init() {
+	caddy.RegisterModule(&Service{})
+}
+
+// Service ticks when the tracked reference of a local repository moves,
+// for eg., when a CI job pushes into a bare repository on the same host.
+//
+// The service watches the references and packed references of repository
+// for changes (with inotify on Linux), so no polling is required.
+type Service struct {
+	// Path of the local repository to watch, either bare or not. Defaults
+	// to the path in the URL of the repository which then should be a
+	// `file://` URL or a plain path.
+	Path string `json:"path,omitempty"`
+
+	info    caddygit.RepositoryInfo
+	gitDir  string
+	refsDir string
+	tick    chan error
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Service) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.fswatch",
+		New: func() caddy.Module { return new(Service) },
+	}
+}
+
+// Provision set's s's configuration for the module.
+func (s *Service) Provision(ctx caddy.Context) error {
+	s.tick = make(chan error, 1)
+	return nil
+}
+
+// ConfigureRepo configures "s" with the repository information.
+func (s *Service) ConfigureRepo(r caddygit.RepositoryInfo) error {
+	s.info = r
+
+	if s.Path == "" {
+		ep, err := transport.NewEndpoint(r.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url: %v", err)
+		}
+
+		if ep.Protocol != "file" {
+			return fmt.Errorf("cannot watch repository with url scheme '%s'", ep.Protocol)
+		}
+
+		s.Path = ep.Path
+	}
+
+	path, err := filepath.Abs(s.Path)
+	if err != nil {
+		return fmt.Errorf("filepath.Abs(%#v): %v", s.Path, err)
+	}
+	s.Path = path
+
+	// The git directory of a non-bare repository is inside its worktree.
+	s.gitDir = s.Path
+	var info os.FileInfo
+	info, err = os.Stat(filepath.Join(s.Path, git.GitDirName))
+	if err == nil && info.IsDir() {
+		s.gitDir = filepath.Join(s.Path, git.GitDirName)
+	}
+
+	s.refsDir = filepath.Join(s.gitDir, "refs")
+	if _, err = os.Stat(s.refsDir); err != nil {
+		return fmt.Errorf("not a git repository: %v", err)
+	}
+
+	return nil
+}
+
+// Start begins the execution of fswatch service. It updates the tick
+// stream every time the tracked reference moves.
+func (s *Service) Start(ctx context.Context) <-chan error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.tick <- fmt.Errorf("cannot create watcher: %v", err)
+		close(s.tick)
+		return s.tick
+	}
+
+	go s.run(ctx, watcher)
+
+	return s.tick
+}
+
+// run watches the repository until ctx is canceled.
+func (s *Service) run(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer close(s.tick)
+	defer watcher.Close() // nolint:errcheck
+
+	// The git directory holds the packed references.
+	if err := watcher.Add(s.gitDir); err != nil {
+		s.send(ctx, fmt.Errorf("cannot watch %s: %v", s.gitDir, err))
+		return
+	}
+
+	if err := s.watchDir(watcher, s.refsDir); err != nil {
+		s.send(ctx, err)
+		return
+	}
+
+	var state string
+	if _, err := s.changed(&state); err != nil {
+		s.send(ctx, err)
+	}
+
+	// update once when the service starts.
+	s.send(ctx, nil)
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if err := s.watchNewDir(watcher, event); err != nil {
+				s.send(ctx, err)
+			}
+
+			if s.isRef(event.Name) {
+				settle = time.After(settleDelay)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			s.send(ctx, fmt.Errorf("error watching repository: %v", err))
+
+		case <-settle:
+			settle = nil
+
+			changed, err := s.changed(&state)
+			if err != nil {
+				s.send(ctx, err)
+				continue
+			}
+
+			if changed {
+				s.send(ctx, nil)
+			}
+		}
+	}
+}
+
+// send ticks with err unless ctx is canceled, in which case no one might
+// be receiving the ticks. Ticks without error are dropped if a tick is
+// already pending since the update it causes updates to the newest
+// reference.
+func (s *Service) send(ctx context.Context, err error) {
+	if err != nil {
+		select {
+		case s.tick <- err:
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	select {
+	case s.tick <- nil:
+	default:
+	}
+}
+
+// watchDir watches dir and all the directories in it, since the watches
+// are not recursive.
+func (s *Service) watchDir(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if err = watcher.Add(path); err != nil {
+			return fmt.Errorf("cannot watch %s: %v", path, err)
+		}
+
+		return nil
+	})
+}
+
+// watchNewDir watches the directory created in the event, if any, for eg.,
+// when a branch `feature/x` is pushed for the first time.
+func (s *Service) watchNewDir(watcher *fsnotify.Watcher, event fsnotify.Event) error {
+	if event.Op&fsnotify.Create == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil || !info.IsDir() {
+		// The file might already be renamed or removed.
+		return nil
+	}
+
+	return s.watchDir(watcher, event.Name)
+}
+
+// isRef tells if the file at path stores references. The lock files git
+// writes the references to before renaming them are ignored.
+func (s *Service) isRef(path string) bool {
+	if strings.HasSuffix(path, ".lock") {
+		return false
+	}
+
+	return path == filepath.Join(s.gitDir, "packed-refs") ||
+		strings.HasPrefix(path, s.refsDir+string(filepath.Separator))
+}
+
+// changed reads the state of repository and tells if it's different from
+// the previous state, which is then updated.
+func (s *Service) changed(previous *string) (bool, error) {
+	state, err := s.state()
+	if err != nil {
+		return false, err
+	}
+
+	if state == *previous {
+		return false, nil
+	}
+
+	*previous = state
+	return true, nil
+}
+
+// state returns the hash of tracked reference. When tracking tags, it
+// returns the hashes of all the tags that can be deployed, so that it
+// changes when a new tag is pushed.
+func (s *Service) state() (string, error) {
+	repo, err := git.PlainOpen(s.Path)
+	if err != nil {
+		return "", fmt.Errorf("cannot open repository: %v", err)
+	}
+
+	if !s.info.LatestTag && s.info.TagConstraint == nil {
+		var ref *plumbing.Reference
+		ref, err = repo.Reference(s.info.ReferenceName, true)
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve '%s': %v", s.info.ReferenceName, err)
+		}
+
+		return ref.Hash().String(), nil
+	}
+
+	tags, err := repo.Tags()
+	if err != nil {
+		return "", fmt.Errorf("cannot list tags: %v", err)
+	}
+
+	var refs []string
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		if s.info.TagConstraint != nil {
+			if _, ok := s.info.TagConstraint.Match(ref.Name().Short()); !ok {
+				return nil
+			}
+		}
+
+		refs = append(refs, ref.Name().String()+" "+ref.Hash().String())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot list tags: %v", err)
+	}
+
+	sort.Strings(refs)
+	return strings.Join(refs, "\n"), nil
+}
+
+// UnmarshalCaddyfile sets up the service from Caddyfile tokens. Syntax:
+//
+// 	fswatch [<path>] {
+// 		path <path>
+// 	}
+func (s *Service) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			s.Path = d.Val()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "path":
+				if !d.AllArgs(&s.Path) {
+					return d.ArgErr()
+				}
+
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guard.
+var (
+	_ caddygit.Service      = (*Service)(nil)
+	_ caddy.Module          = (*Service)(nil)
+	_ caddy.Provisioner     = (*Service)(nil)
+	_ caddyfile.Unmarshaler = (*Service)(nil)
+)
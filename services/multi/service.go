@@ -0,0 +1,246 @@
+package multi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+
+	"github.com/vrongmeal/caddygit"
+	"github.com/vrongmeal/caddygit/module"
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(&Service{})
+}
+
+// Service combines many services into one that ticks whenever any of them
+// ticks, for eg., a webhook for fast updates with polling as a safety net
+// for the missed deliveries.
+//
+// Ticks that arrive within the debounce duration of a tick are merged into
+// it. The service keeps running as long as any of the services does.
+type Service struct {
+	// ServicesRaw are the services to combine.
+	ServicesRaw []json.RawMessage `json:"services,omitempty" caddy:"namespace=git.services inline_key=type"`
+
+	// Debounce is the time to wait for more ticks after a tick before
+	// ticking. Defaults to 2s.
+	Debounce caddy.Duration `json:"debounce,omitempty"`
+
+	Services []caddygit.Service `json:"-"`
+
+	tick chan error
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Service) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.multi",
+		New: func() caddy.Module { return new(Service) },
+	}
+}
+
+// Provision set's s's configuration for the module.
+func (s *Service) Provision(ctx caddy.Context) error {
+	if s.Debounce == 0 {
+		s.Debounce = caddy.Duration(2 * time.Second)
+	}
+
+	servicesIface, err := ctx.LoadModule(s, "ServicesRaw")
+	if err != nil {
+		return fmt.Errorf("error loading module: %v", err)
+	}
+
+	services, ok := servicesIface.([]interface{})
+	if !ok {
+		return fmt.Errorf("invalid services configuration")
+	}
+
+	for i := range services {
+		var service caddygit.Service
+		service, ok = services[i].(caddygit.Service)
+		if !ok {
+			return fmt.Errorf("invalid service configuration")
+		}
+
+		s.Services = append(s.Services, service)
+	}
+
+	s.tick = make(chan error, 1)
+	return nil
+}
+
+// Validate validates s's configuration.
+func (s *Service) Validate() error {
+	if len(s.Services) == 0 {
+		return fmt.Errorf("no services to combine")
+	}
+
+	if s.Debounce < 0 {
+		return fmt.Errorf("debounce should be positive")
+	}
+
+	return nil
+}
+
+// ConfigureRepo configures the services with the repository information.
+func (s *Service) ConfigureRepo(r caddygit.RepositoryInfo) error {
+	for i, service := range s.Services {
+		if err := service.ConfigureRepo(r); err != nil {
+			return fmt.Errorf("service %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// SetDetector sets the detector of the services that only tick on changes.
+func (s *Service) SetDetector(d caddygit.ChangeDetector) {
+	for _, service := range s.Services {
+		if ds, ok := service.(caddygit.DetectingService); ok {
+			ds.SetDetector(d)
+		}
+	}
+}
+
+// Share makes the webhook services without a server of their own use ss.
+// It returns false if none of the services use ss.
+func (s *Service) Share(ss *webhook.SharedServer) bool {
+	shared := false
+	for _, service := range s.Services {
+		if sharer, ok := service.(webhook.Sharer); ok && sharer.Share(ss) {
+			shared = true
+		}
+	}
+
+	return shared
+}
+
+// Start starts all the services and ticks when any of them ticks.
+func (s *Service) Start(ctx context.Context) <-chan error {
+	ticks := make(chan error)
+
+	var wg sync.WaitGroup
+	for _, service := range s.Services {
+		wg.Add(1)
+		go func(serviceTicks <-chan error) {
+			defer wg.Done()
+
+			// The ticks are read until the service stops, even after ctx is
+			// canceled, so that the service is never blocked.
+			for err := range serviceTicks {
+				select {
+				case ticks <- err:
+				case <-ctx.Done():
+				}
+			}
+		}(service.Start(ctx))
+	}
+
+	go func() {
+		wg.Wait()
+		close(ticks)
+	}()
+
+	go s.run(ctx, ticks)
+
+	return s.tick
+}
+
+// run merges the ticks until all the services stop.
+func (s *Service) run(ctx context.Context, ticks <-chan error) {
+	defer close(s.tick)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case err, ok := <-ticks:
+			if !ok {
+				return
+			}
+
+			if err != nil {
+				s.send(ctx, err)
+				continue
+			}
+
+			if debounce == nil {
+				debounce = time.After(time.Duration(s.Debounce))
+			}
+
+		case <-debounce:
+			debounce = nil
+			s.send(ctx, nil)
+		}
+	}
+}
+
+// send ticks with err unless ctx is canceled, in which case no one might
+// be receiving the ticks.
+func (s *Service) send(ctx context.Context, err error) {
+	select {
+	case s.tick <- err:
+	case <-ctx.Done():
+	}
+}
+
+// UnmarshalCaddyfile sets up the service from Caddyfile tokens. Syntax:
+//
+// 	multi {
+// 		service  <type> { ... }
+// 		debounce <duration>
+// 	}
+//
+// The `service` sub-directive can be repeated to combine multiple services.
+func (s *Service) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "service":
+				raw, err := module.UnmarshalModuleRaw(d, "git.services")
+				if err != nil {
+					return err
+				}
+				s.ServicesRaw = append(s.ServicesRaw, raw)
+
+			case "debounce":
+				var debounce string
+				if !d.AllArgs(&debounce) {
+					return d.ArgErr()
+				}
+
+				dur, err := caddy.ParseDuration(debounce)
+				if err != nil {
+					return d.Errf("invalid duration '%s': %v", debounce, err)
+				}
+				s.Debounce = caddy.Duration(dur)
+
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guard.
+var (
+	_ caddygit.Service          = (*Service)(nil)
+	_ caddygit.DetectingService = (*Service)(nil)
+	_ webhook.Sharer            = (*Service)(nil)
+	_ caddy.Module              = (*Service)(nil)
+	_ caddy.Provisioner         = (*Service)(nil)
+	_ caddy.Validator           = (*Service)(nil)
+	_ caddyfile.Unmarshaler     = (*Service)(nil)
+)
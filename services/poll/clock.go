@@ -0,0 +1,36 @@
+package poll
+
+import (
+	"context"
+	"time"
+)
+
+// clock tells the time and waits. The service uses the real clock, which
+// can be replaced to run the service without real waiting.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Sleep waits for d or until ctx is canceled, in which case it returns
+	// the error of ctx.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock is the clock of the system.
+type realClock struct{}
+
+// Now implements the clock interface.
+func (realClock) Now() time.Time { return time.Now() }
+
+// Sleep implements the clock interface.
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,223 @@
+package poll
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronDescriptors are the shorthands of cron expressions.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// cronField is the range of values of a field of cron expression.
+type cronField struct {
+	min, max int
+	names    []string
+
+	// period, if set, is the number of values after which the values of
+	// field repeat, so that its ranges can wrap around, e.g., `fri-mon`.
+	period int
+}
+
+var cronFields = []cronField{
+	{min: 0, max: 59},
+	{min: 0, max: 23},
+	{min: 1, max: 31},
+	{min: 1, max: 12, names: monthNames},
+	// 7 is also sunday.
+	{min: 0, max: 7, names: dayNames, period: 7},
+}
+
+// cronSchedule is a parsed cron expression of the standard five fields
+// (minute, hour, day of month, month and day of week).
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+
+	// domAny and dowAny are set if the day of month or week is `*`. If
+	// both are restricted, a day matching either of them matches.
+	domAny, dowAny bool
+}
+
+// parseCron parses the cron expression, which can also be one of the
+// descriptors like `@hourly`.
+func parseCron(expr string) (*cronSchedule, error) {
+	if descriptor, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule '%s': expected %d fields", expr, len(cronFields))
+	}
+
+	sets := make([][]bool, len(fields))
+	for i, field := range fields {
+		set, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %v", expr, err)
+		}
+
+		sets[i] = set
+	}
+
+	dow := sets[4]
+	dow[0] = dow[0] || dow[7]
+
+	return &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    dow[:7],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parse parses a comma separated list of `*`, values and ranges, each with
+// an optional step (`/n`), into the set of values. A value with a step is
+// the range from the value to the last value of field, i.e., `5/10` is
+// `5-59/10` for minutes.
+func (f cronField) parse(field string) ([]bool, error) {
+	set := make([]bool, f.max+1)
+
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part, stepped = part[:i], true
+		}
+
+		low, high, err := f.parseRange(part)
+		if err != nil {
+			return nil, err
+		}
+
+		if stepped && part != "*" && !strings.Contains(part, "-") {
+			high = f.max
+		}
+
+		for v := low; v <= high; v += step {
+			set[f.wrap(v)] = true
+		}
+	}
+
+	return set, nil
+}
+
+// parseRange parses `*`, a value or a range of values. The high value of a
+// range that wraps around is after the max value of field, see wrap.
+func (f cronField) parseRange(part string) (low, high int, err error) {
+	if part == "*" {
+		return f.min, f.max, nil
+	}
+
+	bounds := strings.SplitN(part, "-", 2)
+
+	low, err = f.parseValue(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	high = low
+	if len(bounds) == 2 {
+		high, err = f.parseValue(bounds[1])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if low > high && f.period > 0 {
+		high += f.period
+	}
+
+	if low > high {
+		return 0, 0, fmt.Errorf("invalid range '%s'", part)
+	}
+
+	return low, high, nil
+}
+
+// wrap returns the value of field that v, which can be after the max value
+// in a range that wraps around, is.
+func (f cronField) wrap(v int) int {
+	if v > f.max {
+		return v - f.period
+	}
+
+	return v
+}
+
+// parseValue parses a number or the name of value.
+func (f cronField) parseValue(val string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(val, name) {
+			return i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(val)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value '%s'", val)
+	}
+
+	return v, nil
+}
+
+// next returns the first time after t that matches the schedule, in the
+// location of t. It returns the zero time if nothing matches in 5 years.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case !c.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !c.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchDay tells if the day of t matches the schedule.
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
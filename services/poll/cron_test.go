@@ -0,0 +1,288 @@
+package poll
+
+import (
+	"testing"
+	"time"
+)
+
+// date returns the time of day in UTC. 2021-03-01 is a monday.
+func date(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+// values returns the values in set.
+func values(set []bool) []int {
+	var vals []int
+	for v, ok := range set {
+		if ok {
+			vals = append(vals, v)
+		}
+	}
+
+	return vals
+}
+
+// equal tells whether a and b have the same values.
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr   string
+		minute []int
+		hour   []int
+		dom    []int
+		month  []int
+		dow    []int
+		domAny bool
+		dowAny bool
+	}{
+		{
+			expr:   "*/15 9-17/4 * * *",
+			minute: []int{0, 15, 30, 45},
+			hour:   []int{9, 13, 17},
+			domAny: true,
+			dowAny: true,
+		},
+		{
+			expr:   "0,30 0 1,15 JAN-mar mon-fri",
+			minute: []int{0, 30},
+			hour:   []int{0},
+			dom:    []int{1, 15},
+			month:  []int{1, 2, 3},
+			dow:    []int{1, 2, 3, 4, 5},
+		},
+		{
+			expr:   "0 0 * * 7",
+			minute: []int{0},
+			hour:   []int{0},
+			dow:    []int{0},
+			domAny: true,
+		},
+		{
+			expr:   "0 0 * * 5-7",
+			minute: []int{0},
+			hour:   []int{0},
+			dow:    []int{0, 5, 6},
+			domAny: true,
+		},
+		{
+			expr:   "5/20 22/1 * * *",
+			minute: []int{5, 25, 45},
+			hour:   []int{22, 23},
+			domAny: true,
+			dowAny: true,
+		},
+		{
+			expr:   "0 0 * nov/2 fri/2",
+			minute: []int{0},
+			hour:   []int{0},
+			month:  []int{11},
+			dow:    []int{0, 5},
+			domAny: true,
+		},
+		{
+			expr:   "0 0 * * fri-sun",
+			minute: []int{0},
+			hour:   []int{0},
+			dow:    []int{0, 5, 6},
+			domAny: true,
+		},
+		{
+			expr:   "0 0 * * sat-2/2",
+			minute: []int{0},
+			hour:   []int{0},
+			dow:    []int{1, 6},
+			domAny: true,
+		},
+		{
+			expr:   "@hourly",
+			minute: []int{0},
+			domAny: true,
+			dowAny: true,
+		},
+		{
+			expr:   " @Weekly ",
+			minute: []int{0},
+			hour:   []int{0},
+			dow:    []int{0},
+			domAny: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.domAny != tt.domAny || c.dowAny != tt.dowAny {
+				t.Errorf("got any day of month %t, week %t; want %t, %t", c.domAny, c.dowAny, tt.domAny, tt.dowAny)
+			}
+
+			fields := []struct {
+				name string
+				set  []bool
+				want []int
+			}{
+				{"minute", c.minute, tt.minute},
+				{"hour", c.hour, tt.hour},
+				{"day of month", c.dom, tt.dom},
+				{"month", c.month, tt.month},
+				{"day of week", c.dow, tt.dow},
+			}
+
+			for _, f := range fields {
+				// Nil is the whole range of the field.
+				if f.want == nil {
+					continue
+				}
+
+				if got := values(f.set); !equal(got, f.want) {
+					t.Errorf("got %s %v; want %v", f.name, got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"30-10 * * * *",
+		"* 20-4 * * *",
+		"* * * dec-jan *",
+		"60/5 * * * *",
+		"* * * foo *",
+		"* * * * funday",
+		"@every 1h",
+	}
+
+	for _, expr := range tests {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parsed invalid schedule '%s'", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			from: date(2021, 3, 1, 10, 7).Add(30 * time.Second),
+			want: date(2021, 3, 1, 10, 15),
+		},
+		{
+			name: "strictly after",
+			expr: "0 * * * *",
+			from: date(2021, 3, 1, 10, 0),
+			want: date(2021, 3, 1, 11, 0),
+		},
+		{
+			name: "day rollover",
+			expr: "30 9 * * *",
+			from: date(2021, 3, 1, 10, 0),
+			want: date(2021, 3, 2, 9, 30),
+		},
+		{
+			name: "month rollover",
+			expr: "0 0 1 * *",
+			from: date(2021, 1, 31, 12, 0),
+			want: date(2021, 2, 1, 0, 0),
+		},
+		{
+			name: "month without the day",
+			expr: "0 0 31 * *",
+			from: date(2021, 1, 31, 0, 0),
+			want: date(2021, 3, 31, 0, 0),
+		},
+		{
+			name: "year rollover",
+			expr: "@monthly",
+			from: date(2021, 12, 15, 0, 0),
+			want: date(2022, 1, 1, 0, 0),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: date(2021, 3, 1, 0, 0),
+			want: date(2024, 2, 29, 0, 0),
+		},
+		{
+			name: "never",
+			expr: "0 0 30 2 *",
+			from: date(2021, 3, 1, 0, 0),
+			want: time.Time{},
+		},
+		{
+			name: "day of week",
+			expr: "30 12 * * sun",
+			from: date(2021, 3, 1, 0, 0),
+			want: date(2021, 3, 7, 12, 30),
+		},
+		{
+			name: "day of month",
+			expr: "0 0 13 * *",
+			from: date(2021, 3, 1, 0, 0),
+			want: date(2021, 3, 13, 0, 0),
+		},
+		{
+			name: "day of month or week matches week",
+			expr: "0 0 13 * fri",
+			from: date(2021, 3, 1, 0, 0),
+			want: date(2021, 3, 5, 0, 0),
+		},
+		{
+			name: "day of month or week matches month",
+			expr: "0 0 13 * fri",
+			from: date(2021, 3, 12, 1, 0),
+			want: date(2021, 3, 13, 0, 0),
+		},
+		{
+			name: "day of week within month",
+			expr: "0 0 * 4 mon",
+			from: date(2021, 3, 1, 0, 0),
+			want: date(2021, 4, 5, 0, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.next(tt.from); !got.Equal(tt.want) {
+				t.Fatalf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
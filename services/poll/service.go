@@ -2,11 +2,15 @@ package poll
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 
 	"github.com/vrongmeal/caddygit"
 )
@@ -15,12 +19,43 @@ func init() {
 	caddy.RegisterModule(&Service{})
 }
 
-// Service is the service that ticks after regular intervals of time.
+// Service is the service that ticks after regular intervals of time, or as
+// per a cron schedule.
+//
+// Before ticking, the service lists the references of remote and only ticks
+// if the repository has changed, so that polling is cheap for the git host.
 type Service struct {
 	// Interval after which the service ticks.
 	Interval caddy.Duration `json:"interval,omitempty"`
 
-	tick chan error
+	// Schedule, if set, is the cron expression (`minute hour day month
+	// weekday` or a descriptor like `@hourly`) of the times to poll at. The
+	// interval is ignored in this case.
+	Schedule string `json:"schedule,omitempty"`
+
+	// Jitter is the maximum random delay added to every poll, so that many
+	// clients don't poll the git host at the same instant.
+	Jitter caddy.Duration `json:"jitter,omitempty"`
+
+	// Windows, if any, are the periods of the week the repository can be
+	// updated in. Changes found outside the windows are queued until the
+	// next window opens.
+	Windows []Window `json:"windows,omitempty"`
+
+	// Timezone is the IANA name (for eg. `Europe/Berlin`) of the timezone of
+	// the schedule and windows. Defaults to the local timezone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// AlwaysUpdate ticks after every interval without checking the remote
+	// for changes.
+	AlwaysUpdate bool `json:"always_update,omitempty"`
+
+	schedule *cronSchedule
+	windows  windows
+	location *time.Location
+	clock    clock
+	detector caddygit.ChangeDetector
+	tick     chan error
 }
 
 // CaddyModule returns the Caddy module information.
@@ -37,61 +72,278 @@ func (s *Service) Provision(ctx caddy.Context) error {
 		s.Interval = caddy.Duration(time.Hour)
 	}
 
+	var err error
+	s.location, err = time.LoadLocation(s.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %v", err)
+	}
+
+	if s.Schedule != "" {
+		s.schedule, err = parseCron(s.Schedule)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range s.Windows {
+		var w *window
+		w, err = parseWindow(&s.Windows[i])
+		if err != nil {
+			return fmt.Errorf("window %d: %v", i, err)
+		}
+
+		s.windows = append(s.windows, w)
+	}
+
+	s.clock = realClock{}
 	s.tick = make(chan error, 1)
 	return nil
 }
 
 // Validate validates s's configuration.
 func (s *Service) Validate() error {
-	if s.Interval < caddy.Duration(5*time.Second) {
+	if s.schedule != nil {
+		if s.schedule.next(time.Now().In(s.location)).IsZero() {
+			return fmt.Errorf("schedule '%s' never matches", s.Schedule)
+		}
+	} else if s.Interval < caddy.Duration(5*time.Second) {
 		return fmt.Errorf(
 			"minimum poll time should be 5 seconds; given %s",
 			time.Duration(s.Interval),
 		)
 	}
 
+	if s.Jitter < 0 {
+		return fmt.Errorf("jitter should be positive")
+	}
+
 	return nil
 }
 
 // ConfigureRepo configures "s" with the repository information.
 func (s *Service) ConfigureRepo(r caddygit.RepositoryInfo) error { return nil }
 
+// SetDetector sets the detector of changes in the repository.
+func (s *Service) SetDetector(d caddygit.ChangeDetector) { s.detector = d }
+
+// changed tells whether the service should tick.
+func (s *Service) changed() (bool, error) {
+	if s.AlwaysUpdate || s.detector == nil {
+		return true, nil
+	}
+
+	return s.detector.Changed()
+}
+
 // Start begins the execution of poll service. It updates the tick stream
-// at regular intervals.
+// at regular intervals (or as per the schedule).
 func (s *Service) Start(ctx context.Context) <-chan error {
-	if s.Interval <= 0 {
+	if s.schedule == nil && s.Interval <= 0 {
 		s.tick <- errors.New("cannot run poll service for non-positive interval")
 		close(s.tick)
 		return s.tick
 	}
 
-	go func(c context.Context, t chan<- error, interval time.Duration) {
-		// update once when the service starts.
-		t <- nil
+	go s.run(ctx)
 
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+	return s.tick
+}
+
+// run polls until ctx is canceled.
+func (s *Service) run(ctx context.Context) {
+	defer close(s.tick)
 
-		for {
-			select {
-			case <-ticker.C:
-				t <- nil
+	// update once when the service starts.
+	changed, err := true, error(nil)
 
-			case <-ctx.Done():
-				t <- ctx.Err()
-				close(t)
+	for {
+		switch {
+		case err != nil:
+			s.send(ctx, err)
+
+		case changed:
+			// Changes found outside the windows are queued until the next
+			// window opens.
+			if s.sleepUntil(ctx, s.windows.nextOpen(s.now())) != nil {
 				return
 			}
+
+			s.send(ctx, nil)
 		}
-	}(ctx, s.tick, time.Duration(s.Interval))
 
-	return s.tick
+		if s.sleepUntil(ctx, s.next()) != nil {
+			return
+		}
+
+		changed, err = s.changed()
+	}
+}
+
+// send ticks with err unless ctx is canceled, in which case no one might
+// be receiving the ticks.
+func (s *Service) send(ctx context.Context, err error) {
+	select {
+	case s.tick <- err:
+	case <-ctx.Done():
+	}
+}
+
+// now returns the current time in the timezone of service.
+func (s *Service) now() time.Time {
+	return s.clock.Now().In(s.location)
+}
+
+// next returns the time of the next poll.
+func (s *Service) next() time.Time {
+	now := s.now()
+
+	next := now.Add(time.Duration(s.Interval))
+	if s.schedule != nil {
+		next = s.schedule.next(now)
+	}
+
+	return next.Add(s.jitter())
+}
+
+// jitter returns a random duration less than the jitter of service.
+func (s *Service) jitter() time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(s.Jitter)))
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(n.Int64())
+}
+
+// sleepUntil waits until t or until ctx is canceled. It returns right away
+// if t is zero or has passed.
+func (s *Service) sleepUntil(ctx context.Context, t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+
+	return s.clock.Sleep(ctx, t.Sub(s.now()))
+}
+
+// UnmarshalCaddyfile sets up the service from Caddyfile tokens. Syntax:
+//
+// 	poll [<interval>] {
+// 		interval <interval>
+// 		schedule <cron expression>
+// 		jitter   <duration>
+// 		window   <days> [<start> <end>]
+// 		timezone <timezone>
+// 		always_update
+// 	}
+//
+// The days of window are comma separated names or ranges, for eg.,
+// `mon-fri` or `sat,sun`. The `window` sub-directive can be repeated.
+func (s *Service) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			if err := s.parseInterval(d); err != nil {
+				return err
+			}
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			if err := s.unmarshalCaddyfileOption(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfileOption parses the sub-directive of service the
+// dispenser is at.
+func (s *Service) unmarshalCaddyfileOption(d *caddyfile.Dispenser) error {
+	switch d.Val() {
+	case "interval":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		if err := s.parseInterval(d); err != nil {
+			return err
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+	case "schedule":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+		s.Schedule = strings.Join(args, " ")
+
+	case "jitter":
+		var jitter string
+		if !d.AllArgs(&jitter) {
+			return d.ArgErr()
+		}
+
+		dur, err := caddy.ParseDuration(jitter)
+		if err != nil {
+			return d.Errf("invalid jitter '%s': %v", jitter, err)
+		}
+		s.Jitter = caddy.Duration(dur)
+
+	case "window":
+		args := d.RemainingArgs()
+		if len(args) != 1 && len(args) != 3 {
+			return d.ArgErr()
+		}
+
+		w := Window{Days: strings.Split(args[0], ",")}
+		if len(args) == 3 {
+			w.Start, w.End = args[1], args[2]
+		}
+		s.Windows = append(s.Windows, w)
+
+	case "timezone":
+		if !d.AllArgs(&s.Timezone) {
+			return d.ArgErr()
+		}
+
+	case "always_update":
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		s.AlwaysUpdate = true
+
+	default:
+		return d.Errf("unrecognized subdirective '%s'", d.Val())
+	}
+
+	return nil
+}
+
+// parseInterval sets the interval from the current token.
+func (s *Service) parseInterval(d *caddyfile.Dispenser) error {
+	interval, err := caddy.ParseDuration(d.Val())
+	if err != nil {
+		return d.Errf("invalid interval '%s': %v", d.Val(), err)
+	}
+
+	s.Interval = caddy.Duration(interval)
+	return nil
 }
 
 // Interface guard.
 var (
-	_ caddygit.Service  = (*Service)(nil)
-	_ caddy.Module      = (*Service)(nil)
-	_ caddy.Provisioner = (*Service)(nil)
-	_ caddy.Validator   = (*Service)(nil)
+	_ caddygit.Service          = (*Service)(nil)
+	_ caddygit.DetectingService = (*Service)(nil)
+	_ caddy.Module              = (*Service)(nil)
+	_ caddy.Provisioner         = (*Service)(nil)
+	_ caddy.Validator           = (*Service)(nil)
+	_ caddyfile.Unmarshaler     = (*Service)(nil)
 )
@@ -0,0 +1,163 @@
+package poll
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// fakeClock is a clock that only moves when the service sleeps. Every sleep
+// is handed to the test through sleeps before the clock moves.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps chan time.Duration
+}
+
+// Now implements the clock interface.
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Sleep implements the clock interface.
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case c.sleeps <- d:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	return nil
+}
+
+// poll is the result of a poll of the detector.
+type poll struct {
+	changed bool
+	err     error
+}
+
+// fakeDetector reports the results of polls in order, and no change
+// afterwards.
+type fakeDetector struct {
+	polls []poll
+}
+
+// Changed implements the caddygit.ChangeDetector interface.
+func (d *fakeDetector) Changed() (bool, error) {
+	if len(d.polls) == 0 {
+		return false, nil
+	}
+
+	p := d.polls[0]
+	d.polls = d.polls[1:]
+	return p.changed, p.err
+}
+
+// tick is a tick of the service and the time it ticked at.
+type tick struct {
+	at  time.Time
+	err error
+}
+
+func TestRunHoldsChangesUntilWindow(t *testing.T) {
+	w, err := parseWindow(&Window{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errFetch := errors.New("cannot fetch")
+
+	c := &fakeClock{now: date(2021, 3, 1, 16, 30), sleeps: make(chan time.Duration)}
+	s := &Service{
+		Interval: caddy.Duration(time.Hour),
+		windows:  windows{w},
+		location: time.UTC,
+		clock:    c,
+		// The polls are at 17:30 on monday, then every hour from 9:00 on
+		// tuesday.
+		detector: &fakeDetector{polls: []poll{
+			{changed: true},
+			{changed: false},
+			{changed: true},
+			{err: errFetch},
+		}},
+		// The tick is not buffered so that the clock can be read before the
+		// service sleeps again.
+		tick: make(chan error),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.run(ctx)
+
+	want := []tick{
+		// The first update is in the window.
+		{at: date(2021, 3, 1, 16, 30)},
+		// The change found after the window closed is held until it opens.
+		{at: date(2021, 3, 2, 9, 0)},
+		// The change found in the window is not held.
+		{at: date(2021, 3, 2, 11, 0)},
+		// The errors are ticked right away.
+		{at: date(2021, 3, 2, 12, 0), err: errFetch},
+	}
+
+	var got []tick
+	for len(got) < len(want) {
+		select {
+		case <-c.sleeps:
+		case err := <-s.tick:
+			got = append(got, tick{at: c.Now(), err: err})
+		case <-time.After(time.Second):
+			t.Fatalf("got %d ticks; want %d", len(got), len(want))
+		}
+	}
+
+	for i := range want {
+		if !got[i].at.Equal(want[i].at) || got[i].err != want[i].err {
+			t.Errorf("tick %d: got %v at %s; want %v at %s", i, got[i].err, got[i].at, want[i].err, want[i].at)
+		}
+	}
+
+	cancel()
+	for range s.tick {
+	}
+}
+
+func TestRunStopsWithoutReceiver(t *testing.T) {
+	c := &fakeClock{now: date(2021, 3, 1, 16, 30), sleeps: make(chan time.Duration)}
+	s := &Service{
+		Interval: caddy.Duration(time.Hour),
+		location: time.UTC,
+		clock:    c,
+		tick:     make(chan error),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	// No one receives the ticks after the service is stopped.
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("service blocked after it was stopped")
+	}
+}
@@ -0,0 +1,144 @@
+package poll
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a period of the week in which the repository can be updated.
+type Window struct {
+	// Days of the week the window starts on, either names (`mon`) or ranges
+	// of names (`mon-fri`, `fri-mon`). Defaults to all days.
+	Days []string `json:"days,omitempty"`
+
+	// Start and End are the times of day (`15:04`) the window starts and
+	// ends at. Default to the whole day. If the end is not after the start,
+	// the window ends on the next day.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// window is a parsed Window.
+type window struct {
+	days       [7]bool
+	start, end time.Duration
+}
+
+// parseWindow parses w.
+func parseWindow(w *Window) (*window, error) {
+	pw := &window{}
+
+	if len(w.Days) == 0 {
+		for i := range pw.days {
+			pw.days[i] = true
+		}
+	}
+
+	f := cronFields[4]
+	for _, days := range w.Days {
+		low, high, err := f.parseRange(days)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window days: %v", err)
+		}
+
+		for d := low; d <= high; d++ {
+			pw.days[d%7] = true
+		}
+	}
+
+	var err error
+	pw.start, err = parseTimeOfDay(w.Start, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	pw.end, err = parseTimeOfDay(w.End, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	if pw.end <= pw.start {
+		pw.end += 24 * time.Hour
+	}
+
+	return pw, nil
+}
+
+// parseTimeOfDay parses the `15:04` time of day into the duration since
+// midnight. It returns def if the time is empty.
+func parseTimeOfDay(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time of day '%s'", s)
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid time of day '%s'", s)
+	}
+
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time of day '%s'", s)
+	}
+
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, nil
+}
+
+// bounds returns the start and end of the window starting on the day of t.
+// The bool is false if the window does not start on that day.
+func (w *window) bounds(t time.Time) (start, end time.Time, ok bool) {
+	if !w.days[t.Weekday()] {
+		return start, end, false
+	}
+
+	// The minutes are normalized by time.Date so that the times are of the
+	// wall clock even on the days daylight saving time changes.
+	year, month, day := t.Date()
+	start = time.Date(year, month, day, 0, int(w.start/time.Minute), 0, 0, t.Location())
+	end = time.Date(year, month, day, 0, int(w.end/time.Minute), 0, 0, t.Location())
+	return start, end, true
+}
+
+// windows are the periods in which the repository can be updated. Any time
+// is in the windows if there are none.
+type windows []*window
+
+// nextOpen returns t if it's in any of the windows, else the time the first
+// window after t starts. It returns the zero time if no window ever starts.
+func (ws windows) nextOpen(t time.Time) time.Time {
+	if len(ws) == 0 {
+		return t
+	}
+
+	var open time.Time
+
+	// Windows span at most two days, so the windows that started yesterday
+	// might still be open while the next window starts within a week.
+	for offset := -1; offset <= 7; offset++ {
+		day := t.AddDate(0, 0, offset)
+
+		for _, w := range ws {
+			start, end, ok := w.bounds(day)
+			if !ok {
+				continue
+			}
+
+			if !t.Before(start) && t.Before(end) {
+				return t
+			}
+
+			if start.After(t) && (open.IsZero() || start.Before(open)) {
+				open = start
+			}
+		}
+	}
+
+	return open
+}
@@ -0,0 +1,120 @@
+package poll
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindowInvalid(t *testing.T) {
+	tests := []Window{
+		{Days: []string{"funday"}},
+		{Days: []string{"fri-funday"}},
+		{Start: "9"},
+		{Start: "25:00"},
+		{Start: "12:60"},
+		{End: "24:30"},
+		{End: "noon"},
+	}
+
+	for i := range tests {
+		if _, err := parseWindow(&tests[i]); err == nil {
+			t.Errorf("parsed invalid window %+v", tests[i])
+		}
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []Window
+		at      time.Time
+		want    time.Time
+	}{
+		{
+			name: "no windows",
+			at:   date(2021, 3, 6, 3, 0),
+			want: date(2021, 3, 6, 3, 0),
+		},
+		{
+			name:    "in window",
+			windows: []Window{{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"}},
+			at:      date(2021, 3, 1, 10, 0),
+			want:    date(2021, 3, 1, 10, 0),
+		},
+		{
+			name:    "before window",
+			windows: []Window{{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"}},
+			at:      date(2021, 3, 1, 8, 0),
+			want:    date(2021, 3, 1, 9, 0),
+		},
+		{
+			name:    "end of window",
+			windows: []Window{{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"}},
+			at:      date(2021, 3, 1, 17, 0),
+			want:    date(2021, 3, 2, 9, 0),
+		},
+		{
+			name:    "weekend",
+			windows: []Window{{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"}},
+			at:      date(2021, 3, 5, 18, 0),
+			want:    date(2021, 3, 8, 9, 0),
+		},
+		{
+			name:    "overnight window started yesterday",
+			windows: []Window{{Days: []string{"sat"}, Start: "22:00", End: "02:00"}},
+			at:      date(2021, 3, 7, 1, 0),
+			want:    date(2021, 3, 7, 1, 0),
+		},
+		{
+			name:    "overnight window next week",
+			windows: []Window{{Days: []string{"sat"}, Start: "22:00", End: "02:00"}},
+			at:      date(2021, 3, 7, 3, 0),
+			want:    date(2021, 3, 13, 22, 0),
+		},
+		{
+			name:    "all days",
+			windows: []Window{{Start: "22:00", End: "06:00"}},
+			at:      date(2021, 3, 3, 12, 0),
+			want:    date(2021, 3, 3, 22, 0),
+		},
+		{
+			name:    "whole day",
+			windows: []Window{{Days: []string{"sun"}}},
+			at:      date(2021, 3, 6, 23, 59),
+			want:    date(2021, 3, 7, 0, 0),
+		},
+		{
+			name:    "days wrap around",
+			windows: []Window{{Days: []string{"fri-mon"}, Start: "09:00", End: "17:00"}},
+			at:      date(2021, 3, 2, 10, 0),
+			want:    date(2021, 3, 5, 9, 0),
+		},
+		{
+			name: "first of windows",
+			windows: []Window{
+				{Days: []string{"tue"}, Start: "12:00", End: "13:00"},
+				{Days: []string{"mon"}, Start: "18:00", End: "19:00"},
+			},
+			at:   date(2021, 3, 1, 13, 0),
+			want: date(2021, 3, 1, 18, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ws windows
+			for i := range tt.windows {
+				w, err := parseWindow(&tt.windows[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ws = append(ws, w)
+			}
+
+			if got := ws.nextOpen(tt.at); !got.Equal(tt.want) {
+				t.Fatalf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
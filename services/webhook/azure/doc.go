@@ -0,0 +1,3 @@
+// Package azure implements the webhook service compatible with Azure DevOps
+// Repos service hooks.
+package azure
@@ -0,0 +1,196 @@
+package azure
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(Webhook{})
+}
+
+// zeroObjectID is the object ID of a ref that is deleted.
+const zeroObjectID = "0000000000000000000000000000000000000000"
+
+// Webhook implements a hook type which can be used to host the a project
+// maintained on Azure DevOps Repos.
+type Webhook struct {
+	// Username and secret for the basic authentication of the service hook.
+	// The secret defaults to the secret of webhook service.
+	Username string `json:"auth_user,omitempty"`
+	Password string `json:"auth_secret,omitempty"`
+}
+
+type pushBody struct {
+	EventType string `json:"eventType"`
+	Resource  struct {
+		RefUpdates []struct {
+			Name        string `json:"name"`
+			NewObjectID string `json:"newObjectId"`
+		} `json:"refUpdates"`
+		Repository struct {
+			RemoteURL string `json:"remoteUrl"`
+			SSHURL    string `json:"sshUrl"`
+		} `json:"repository"`
+	} `json:"resource"`
+}
+
+// CaddyModule returns the caddy module information.
+func (Webhook) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.webhook.azure",
+		New: func() caddy.Module { return new(Webhook) },
+	}
+}
+
+// Provision set's up w's configuration.
+func (w *Webhook) Provision(ctx caddy.Context) error {
+	repl := caddy.NewReplacer()
+	replaceableFields := []*string{
+		&w.Username,
+		&w.Password,
+	}
+	for _, field := range replaceableFields {
+		actual, err := repl.ReplaceOrErr(*field, false, true)
+		if err != nil {
+			return fmt.Errorf("error replacing fields: %v", err)
+		}
+
+		*field = actual
+	}
+
+	return nil
+}
+
+// Handle implements the webhook.Webhook interface.
+func (w *Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+	if err := webhook.ValidateRequest(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := w.authenticate(req, hc); err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return webhook.ReadErrorStatus(err), err
+	}
+
+	var rBody pushBody
+
+	err = json.Unmarshal(body, &rBody)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if rBody.EventType != "git.push" {
+		return http.StatusBadRequest, fmt.Errorf("cannot handle %q event", rBody.EventType)
+	}
+
+	repo := rBody.Resource.Repository
+	err = webhook.ValidateRepo([]string{repo.RemoteURL, repo.SSHURL}, hc)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var refNames []plumbing.ReferenceName
+	for _, update := range rBody.Resource.RefUpdates {
+		if update.NewObjectID == zeroObjectID {
+			continue
+		}
+
+		refNames = append(refNames, plumbing.ReferenceName(update.Name))
+	}
+
+	err = webhook.ValidateAnyRef(refNames, &hc.RepoInfo)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// authenticate validates the basic authentication credentials of request,
+// if any credentials are configured.
+func (w *Webhook) authenticate(req *http.Request, hc *webhook.HookConf) error {
+	password := w.Password
+	if password == "" {
+		password = hc.Secret
+	}
+
+	if w.Username == "" && password == "" {
+		return nil
+	}
+
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return fmt.Errorf("basic authentication missing")
+	}
+
+	userOk := subtle.ConstantTimeCompare([]byte(user), []byte(w.Username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+	if !userOk || !passOk {
+		return fmt.Errorf("invalid credentials")
+	}
+
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. Syntax:
+//
+// 	azure [<username> [<password>]] {
+// 		auth_user   <username>
+// 		auth_secret <password>
+// 	}
+func (w *Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		args := d.RemainingArgs()
+		switch len(args) {
+		case 0:
+		case 2:
+			w.Password = args[1]
+			fallthrough
+		case 1:
+			w.Username = args[0]
+		default:
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "auth_user":
+				if !d.AllArgs(&w.Username) {
+					return d.ArgErr()
+				}
+
+			case "auth_secret":
+				if !d.AllArgs(&w.Password) {
+					return d.ArgErr()
+				}
+
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ caddy.Module          = (*Webhook)(nil)
+	_ caddy.Provisioner     = (*Webhook)(nil)
+	_ webhook.Webhook       = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
+)
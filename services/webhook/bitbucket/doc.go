@@ -0,0 +1,3 @@
+// Package bitbucket implements the webhook services compatible with
+// Bitbucket Cloud and Bitbucket Server (Data Center).
+package bitbucket
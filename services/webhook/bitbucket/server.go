@@ -0,0 +1,123 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(Server{})
+}
+
+// Server implements a hook type which can be used to host the a project
+// maintained on Bitbucket Server or Data Center.
+type Server struct{}
+
+type refsChangedBody struct {
+	Changes []struct {
+		Ref struct {
+			ID string `json:"id"`
+		} `json:"ref"`
+		Type string `json:"type"`
+	} `json:"changes"`
+	Repository struct {
+		Links struct {
+			Clone []struct {
+				Href string `json:"href"`
+			} `json:"clone"`
+		} `json:"links"`
+	} `json:"repository"`
+}
+
+// CaddyModule returns the caddy module information.
+func (Server) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.webhook.bitbucket_server",
+		New: func() caddy.Module { return new(Server) },
+	}
+}
+
+// Handle implements the webhook.Webhook interface.
+func (Server) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+	if err := webhook.ValidateRequest(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return webhook.ReadErrorStatus(err), err
+	}
+
+	err = validateSignature(req, hc, body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	event := req.Header.Get("X-Event-Key")
+	if event == "" {
+		return http.StatusBadRequest, fmt.Errorf("header 'X-Event-Key' missing")
+	}
+
+	switch event {
+	case "diagnostics:ping":
+		return http.StatusOK, webhook.ErrNoUpdate
+	case "repo:refs_changed":
+		var rBody refsChangedBody
+
+		err = json.Unmarshal(body, &rBody)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		var urls []string
+		for _, link := range rBody.Repository.Links.Clone {
+			urls = append(urls, link.Href)
+		}
+
+		err = webhook.ValidateRepo(urls, hc)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		var refNames []plumbing.ReferenceName
+		for _, change := range rBody.Changes {
+			if change.Type == "DELETE" {
+				continue
+			}
+
+			refNames = append(refNames, plumbing.ReferenceName(change.Ref.ID))
+		}
+
+		err = webhook.ValidateAnyRef(refNames, &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	default:
+		return http.StatusBadRequest, fmt.Errorf("cannot handle %q event", event)
+	}
+
+	return http.StatusOK, nil
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. The hook has
+// no options. Syntax:
+//
+// 	bitbucket_server
+func (*Server) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	return unmarshalCaddyfile(d)
+}
+
+// Interface guards.
+var (
+	_ caddy.Module          = (*Server)(nil)
+	_ webhook.Webhook       = (*Server)(nil)
+	_ caddyfile.Unmarshaler = (*Server)(nil)
+)
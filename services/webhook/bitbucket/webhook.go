@@ -0,0 +1,151 @@
+package bitbucket
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(Webhook{})
+}
+
+// Webhook implements a hook type which can be used to host the a project
+// maintained on Bitbucket Cloud.
+type Webhook struct{}
+
+type pushBody struct {
+	Push struct {
+		Changes []struct {
+			// New is the state of ref after the push. It's nil if the
+			// ref is deleted.
+			New *struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	Repository struct {
+		Links struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+}
+
+// CaddyModule returns the caddy module information.
+func (Webhook) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.webhook.bitbucket",
+		New: func() caddy.Module { return new(Webhook) },
+	}
+}
+
+// Handle implements the webhook.Webhook interface.
+func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+	if err := webhook.ValidateRequest(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return webhook.ReadErrorStatus(err), err
+	}
+
+	err = validateSignature(req, hc, body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	event := req.Header.Get("X-Event-Key")
+	if event == "" {
+		return http.StatusBadRequest, fmt.Errorf("header 'X-Event-Key' missing")
+	}
+
+	if event != "repo:push" {
+		return http.StatusBadRequest, fmt.Errorf("cannot handle %q event", event)
+	}
+
+	var rBody pushBody
+
+	err = json.Unmarshal(body, &rBody)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	err = webhook.ValidateRepo([]string{rBody.Repository.Links.HTML.Href}, hc)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var refNames []plumbing.ReferenceName
+	for _, change := range rBody.Push.Changes {
+		if change.New == nil {
+			continue
+		}
+
+		switch change.New.Type {
+		case "branch":
+			refNames = append(refNames, plumbing.NewBranchReferenceName(change.New.Name))
+		case "tag", "annotated_tag":
+			refNames = append(refNames, plumbing.NewTagReferenceName(change.New.Name))
+		}
+	}
+
+	err = webhook.ValidateAnyRef(refNames, &hc.RepoInfo)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. The hook has
+// no options. Syntax:
+//
+// 	bitbucket
+func (*Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	return unmarshalCaddyfile(d)
+}
+
+// validateSignature validates the `X-Hub-Signature` header of request, if
+// either the header or the secret is set.
+func validateSignature(req *http.Request, hc *webhook.HookConf, body []byte) error {
+	signature := req.Header.Get("X-Hub-Signature")
+	if signature == "" && hc.Secret == "" {
+		return nil
+	}
+
+	return webhook.ValidateSignature(sha256.New, hc.Secret, body, signature, "sha256=")
+}
+
+// unmarshalCaddyfile ensures that the hook has no options.
+func unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		if d.NextBlock(0) {
+			return d.Errf("unrecognized subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ caddy.Module          = (*Webhook)(nil)
+	_ webhook.Webhook       = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
+)
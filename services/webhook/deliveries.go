@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrDuplicateDelivery is returned by the service when the request is a
+// delivery that has already been handled. The request is responded to with
+// status OK and the service does not tick.
+var ErrDuplicateDelivery = errors.New("duplicate delivery")
+
+// DefaultDeliveryHeaders are the headers of a request that uniquely
+// identify the delivery of a webhook event.
+var DefaultDeliveryHeaders = []string{
+	"X-GitHub-Delivery",
+	"X-Gitlab-Event-UUID",
+	"X-Gitea-Delivery",
+	"X-Forgejo-Delivery",
+	"X-Gogs-Delivery",
+	"X-Request-UUID",
+	"X-Request-Id",
+	"X-Amz-Sns-Message-Id",
+	"X-Delivery-Id",
+}
+
+// deliveries is a set of delivery IDs of bounded size in which the IDs
+// expire after the TTL.
+type deliveries struct {
+	ttl  time.Duration
+	size int
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// order has the IDs in the order they were added, which is the same
+	// as the order in which they expire.
+	order []string
+}
+
+// newDeliveries creates a set that keeps at most size IDs for ttl.
+func newDeliveries(ttl time.Duration, size int) *deliveries {
+	return &deliveries{
+		ttl:  ttl,
+		size: size,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// add adds id to the set at time now. It returns false if the id is already
+// in the set.
+func (d *deliveries) add(id string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.order) > 0 && now.Sub(d.seen[d.order[0]]) > d.ttl {
+		d.evict()
+	}
+
+	if _, ok := d.seen[id]; ok {
+		return false
+	}
+
+	for len(d.order) >= d.size {
+		d.evict()
+	}
+
+	d.seen[id] = now
+	d.order = append(d.order, id)
+	return true
+}
+
+// evict removes the oldest ID from the set.
+func (d *deliveries) evict() {
+	delete(d.seen, d.order[0])
+	d.order = d.order[1:]
+}
+
+// deliveryID returns the ID of delivery from the first of headers set in
+// req. It returns an empty string if none of the headers is set.
+func deliveryID(req *http.Request, headers []string) string {
+	for _, header := range headers {
+		if id := req.Header.Get(header); id != "" {
+			return header + ":" + id
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeliveriesAdd(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	type add struct {
+		id    string
+		after time.Duration
+		ok    bool
+	}
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		size int
+		adds []add
+	}{
+		{
+			name: "duplicate within ttl",
+			ttl:  time.Hour,
+			size: 10,
+			adds: []add{
+				{id: "a", after: 0, ok: true},
+				{id: "b", after: time.Minute, ok: true},
+				{id: "a", after: 30 * time.Minute, ok: false},
+				{id: "a", after: time.Hour, ok: false},
+			},
+		},
+		{
+			name: "accepted again after ttl",
+			ttl:  time.Hour,
+			size: 10,
+			adds: []add{
+				{id: "a", after: 0, ok: true},
+				{id: "a", after: time.Hour + time.Second, ok: true},
+				{id: "a", after: 90 * time.Minute, ok: false},
+			},
+		},
+		{
+			name: "oldest evicted at size",
+			ttl:  time.Hour,
+			size: 2,
+			adds: []add{
+				{id: "a", after: 0, ok: true},
+				{id: "b", after: time.Second, ok: true},
+				{id: "c", after: 2 * time.Second, ok: true},
+				{id: "b", after: 3 * time.Second, ok: false},
+				{id: "c", after: 4 * time.Second, ok: false},
+				{id: "a", after: 5 * time.Second, ok: true},
+				{id: "b", after: 6 * time.Second, ok: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDeliveries(tt.ttl, tt.size)
+			for i, a := range tt.adds {
+				if ok := d.add(a.id, start.Add(a.after)); ok != a.ok {
+					t.Fatalf("add %d (%s after %s): got %t; want %t", i, a.id, a.after, ok, a.ok)
+				}
+
+				if len(d.seen) > tt.size || len(d.order) != len(d.seen) {
+					t.Fatalf("add %d: %d seen and %d ordered IDs; want at most %d",
+						i, len(d.seen), len(d.order), tt.size)
+				}
+			}
+		})
+	}
+}
+
+// okHook is a hook that accepts every request.
+type okHook struct{}
+
+func (okHook) Handle(*http.Request, *HookConf) (int, error) { return http.StatusOK, nil }
+
+func TestHandleDuplicateDelivery(t *testing.T) {
+	s := &Service{
+		Hook:            okHook{},
+		DeliveryHeaders: DefaultDeliveryHeaders,
+		deliveries:      newDeliveries(time.Hour, 10),
+	}
+
+	request := func(header, id string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if id != "" {
+			req.Header.Set(header, id)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		id     string
+		err    error
+	}{
+		{name: "first delivery", header: "X-GitHub-Delivery", id: "1", err: nil},
+		{name: "duplicate delivery", header: "X-GitHub-Delivery", id: "1", err: ErrDuplicateDelivery},
+		{name: "other delivery", header: "X-GitHub-Delivery", id: "2", err: nil},
+		{name: "same id of other header", header: "X-Gitlab-Event-UUID", id: "1", err: nil},
+		{name: "no delivery id", header: "X-GitHub-Delivery", id: "", err: nil},
+		{name: "no delivery id again", header: "X-GitHub-Delivery", id: "", err: nil},
+	}
+
+	for _, tt := range tests {
+		sc, err := s.handle(request(tt.header, tt.id), false)
+		if err != tt.err || sc != http.StatusOK {
+			t.Fatalf("%s: got %d, %v; want %d, %v", tt.name, sc, err, http.StatusOK, tt.err)
+		}
+	}
+}
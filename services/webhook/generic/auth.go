@@ -0,0 +1,103 @@
+package generic
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+// Modes of authentication of the webhook requests.
+const (
+	// AuthSignature authenticates the HMAC-SHA256 signature of body.
+	AuthSignature = "signature"
+	// AuthBearer authenticates the secret as the bearer token.
+	AuthBearer = "bearer"
+	// AuthQuery authenticates the secret as a query parameter.
+	AuthQuery = "query"
+)
+
+// Defaults for the webhook configuration.
+const (
+	defaultSignatureHeader    = "X-Signature"
+	defaultSignaturePrefix    = "sha256="
+	defaultTokenParam         = "token"
+	defaultTimestampTolerance = 5 * time.Minute
+)
+
+// authenticate validates that req is authorized by the secret of hc at time
+// now.
+func (w *Webhook) authenticate(req *http.Request, hc *webhook.HookConf, body []byte, now time.Time) error {
+	var timestamp string
+	if w.TimestampHeader != "" {
+		timestamp = req.Header.Get(w.TimestampHeader)
+		if err := w.validateTimestamp(timestamp, hc, now); err != nil {
+			return err
+		}
+	}
+
+	switch w.Auth {
+	case AuthSignature:
+		payload := body
+		if timestamp != "" {
+			payload = signedPayload(timestamp, body)
+		}
+
+		signature := req.Header.Get(w.SignatureHeader)
+		return webhook.ValidateSignature(sha256.New, hc.Secret, payload, signature, w.SignaturePrefix)
+	case AuthBearer:
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		return validateToken(token, hc.Secret)
+	case AuthQuery:
+		return validateToken(req.URL.Query().Get(w.TokenParam), hc.Secret)
+	default:
+		return fmt.Errorf("invalid auth mode %q", w.Auth)
+	}
+}
+
+// validateTimestamp ensures that the unix time timestamp is within the
+// tolerance of now.
+func (w *Webhook) validateTimestamp(timestamp string, hc *webhook.HookConf, now time.Time) error {
+	if timestamp == "" {
+		return fmt.Errorf("header '%s' missing", w.TimestampHeader)
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+
+	tolerance := time.Duration(w.TimestampTolerance)
+	if tolerance == 0 {
+		tolerance = hc.ReplayWindow
+	}
+	if tolerance == 0 {
+		tolerance = defaultTimestampTolerance
+	}
+
+	return webhook.ValidateTimestamp(time.Unix(sec, 0), now, tolerance)
+}
+
+// signedPayload returns the payload that is signed when the request has a
+// timestamp, i.e., `<timestamp>.<body>`.
+func signedPayload(timestamp string, body []byte) []byte {
+	return append([]byte(timestamp+"."), body...)
+}
+
+// validateToken compares token with secret in constant time.
+func validateToken(token, secret string) error {
+	if token == "" {
+		return fmt.Errorf("token missing")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+
+	return nil
+}
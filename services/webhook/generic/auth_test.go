@@ -0,0 +1,196 @@
+package generic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+const (
+	secret = "It's a Secret to Everybody"
+	body   = `{"ref": "refs/heads/master"}`
+)
+
+// signature returns the signature header of payload.
+func signature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload)) // nolint:errcheck
+	return defaultSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newHook returns a hook with the defaults set.
+func newHook(auth, timestampHeader string) *Webhook {
+	return &Webhook{
+		Auth:            auth,
+		SignatureHeader: defaultSignatureHeader,
+		SignaturePrefix: defaultSignaturePrefix,
+		TokenParam:      defaultTokenParam,
+		TimestampHeader: timestampHeader,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		hook      *Webhook
+		target    string
+		headers   map[string]string
+		replay    time.Duration
+		tolerance time.Duration
+		ok        bool
+	}{
+		{
+			name:    "signature",
+			hook:    newHook(AuthSignature, ""),
+			headers: map[string]string{"X-Signature": signature(body)},
+			ok:      true,
+		},
+		{
+			name:    "signature of other body",
+			hook:    newHook(AuthSignature, ""),
+			headers: map[string]string{"X-Signature": signature(`{"ref": "refs/heads/dev"}`)},
+		},
+		{
+			name: "signature missing",
+			hook: newHook(AuthSignature, ""),
+		},
+		{
+			name: "signature with timestamp",
+			hook: newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{
+				"X-Timestamp": ts,
+				"X-Signature": signature(ts + "." + body),
+			},
+			ok: true,
+		},
+		{
+			name: "signature without the signed timestamp",
+			hook: newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{
+				"X-Timestamp": ts,
+				"X-Signature": signature(body),
+			},
+		},
+		{
+			name: "signature with other timestamp",
+			hook: newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{
+				"X-Timestamp": ts,
+				"X-Signature": signature(stale + "." + body),
+			},
+		},
+		{
+			name: "stale timestamp",
+			hook: newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{
+				"X-Timestamp": stale,
+				"X-Signature": signature(stale + "." + body),
+			},
+		},
+		{
+			name: "stale timestamp within tolerance",
+			hook: newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{
+				"X-Timestamp": stale,
+				"X-Signature": signature(stale + "." + body),
+			},
+			tolerance: 2 * time.Hour,
+			ok:        true,
+		},
+		{
+			name: "stale timestamp within replay window",
+			hook: newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{
+				"X-Timestamp": stale,
+				"X-Signature": signature(stale + "." + body),
+			},
+			replay: 2 * time.Hour,
+			ok:     true,
+		},
+		{
+			name:    "timestamp missing",
+			hook:    newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{"X-Signature": signature("." + body)},
+		},
+		{
+			name: "timestamp not a number",
+			hook: newHook(AuthSignature, "X-Timestamp"),
+			headers: map[string]string{
+				"X-Timestamp": "yesterday",
+				"X-Signature": signature("yesterday." + body),
+			},
+		},
+		{
+			name:    "bearer",
+			hook:    newHook(AuthBearer, ""),
+			headers: map[string]string{"Authorization": "Bearer " + secret},
+			ok:      true,
+		},
+		{
+			name:    "bearer wrong token",
+			hook:    newHook(AuthBearer, ""),
+			headers: map[string]string{"Authorization": "Bearer " + secret + "!"},
+		},
+		{
+			name: "bearer missing",
+			hook: newHook(AuthBearer, ""),
+		},
+		{
+			name:   "query",
+			hook:   newHook(AuthQuery, ""),
+			target: "/?token=" + strings.ReplaceAll(secret, " ", "+"),
+			ok:     true,
+		},
+		{
+			name:   "query wrong token",
+			hook:   newHook(AuthQuery, ""),
+			target: "/?token=secret",
+		},
+		{
+			name:   "query other param",
+			hook:   newHook(AuthQuery, ""),
+			target: "/?secret=" + strings.ReplaceAll(secret, " ", "+"),
+		},
+		{
+			name:    "unknown mode",
+			hook:    newHook("basic", ""),
+			headers: map[string]string{"X-Signature": signature(body)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+			for key, val := range tt.headers {
+				req.Header.Set(key, val)
+			}
+
+			hc := &webhook.HookConf{Secret: secret, ReplayWindow: tt.replay}
+			tt.hook.TimestampTolerance = caddy.Duration(tt.tolerance)
+
+			err := tt.hook.authenticate(req, hc, []byte(body), now)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v; want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/go-git/go-git/v5/plumbing"
 
 	"github.com/vrongmeal/caddygit/services/webhook"
@@ -20,11 +22,42 @@ func init() {
 type reqBody struct {
 	// Ref is the reference name of the repository.
 	Ref string `json:"ref"`
+
+	// Repository is the URL of the repository. It's only required when
+	// the request is handled by the services of multiple repositories.
+	Repository string `json:"repository"`
 }
 
 // Webhook implements a hook type which can be used independent of platform
 // used to host the git repository.
-type Webhook struct{}
+//
+// If the webhook secret is set, requests are authenticated with it as per
+// the auth mode.
+type Webhook struct {
+	// Auth is the mode of authentication, one of `signature`, `bearer` or
+	// `query`. Defaults to `signature`.
+	Auth string `json:"auth,omitempty"`
+
+	// SignatureHeader is the header with the HMAC-SHA256 signature of body
+	// when auth mode is `signature`. Defaults to `X-Signature`.
+	SignatureHeader string `json:"signature_header,omitempty"`
+
+	// SignaturePrefix is the prefix of the hex encoded signature. Defaults
+	// to `sha256=`.
+	SignaturePrefix string `json:"signature_prefix,omitempty"`
+
+	// TokenParam is the query parameter with the secret when auth mode is
+	// `query`. Defaults to `token`.
+	TokenParam string `json:"token_param,omitempty"`
+
+	// TimestampHeader, if set, is the header with the unix time (seconds) of
+	// the request. Requests older than TimestampTolerance are rejected. When
+	// auth mode is `signature`, the signed payload is `<timestamp>.<body>`.
+	// The tolerance defaults to the replay window of webhook service, or 5
+	// minutes if that is not set either.
+	TimestampHeader    string         `json:"timestamp_header,omitempty"`
+	TimestampTolerance caddy.Duration `json:"timestamp_tolerance,omitempty"`
+}
 
 // CaddyModule returns the caddy module information.
 func (Webhook) CaddyModule() caddy.ModuleInfo {
@@ -34,15 +67,58 @@ func (Webhook) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
+// Provision set's up w's configuration.
+func (w *Webhook) Provision(ctx caddy.Context) error {
+	if w.Auth == "" {
+		w.Auth = AuthSignature
+	}
+
+	if w.SignatureHeader == "" {
+		w.SignatureHeader = defaultSignatureHeader
+	}
+
+	if w.SignaturePrefix == "" {
+		w.SignaturePrefix = defaultSignaturePrefix
+	}
+
+	if w.TokenParam == "" {
+		w.TokenParam = defaultTokenParam
+	}
+
+	return nil
+}
+
+// Validate ensures w's configuration is valid.
+func (w *Webhook) Validate() error {
+	switch w.Auth {
+	case AuthSignature, AuthBearer, AuthQuery:
+	default:
+		return fmt.Errorf("invalid auth mode %q", w.Auth)
+	}
+
+	if w.TimestampTolerance < 0 {
+		return fmt.Errorf("timestamp tolerance should be positive")
+	}
+
+	return nil
+}
+
 // Handle implements the webhook.Webhook interface.
-func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+func (w *Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
 	if err := webhook.ValidateRequest(req); err != nil {
 		return http.StatusBadRequest, err
 	}
 
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return http.StatusRequestTimeout, err
+		return webhook.ReadErrorStatus(err), err
+	}
+
+	if hc.Secret != "" {
+		err = w.authenticate(req, hc, body, time.Now())
+		if err != nil {
+			return http.StatusUnauthorized, err
+		}
 	}
 
 	var rBody reqBody
@@ -52,25 +128,82 @@ func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
 		return http.StatusBadRequest, err
 	}
 
-	refName := plumbing.ReferenceName(rBody.Ref)
-	if refName.IsBranch() {
-		if refName != hc.RepoInfo.ReferenceName {
-			return http.StatusBadRequest, fmt.Errorf("event: push to branch %s", refName)
+	err = webhook.ValidateRepo([]string{rBody.Repository}, hc)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	err = webhook.ValidateRef(plumbing.ReferenceName(rBody.Ref), &hc.RepoInfo)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. Syntax:
+//
+// 	generic [<auth>] {
+// 		auth                <auth>
+// 		signature_header    <header>
+// 		signature_prefix    <prefix>
+// 		token_param         <param>
+// 		timestamp_header    <header>
+// 		timestamp_tolerance <duration>
+// 	}
+func (w *Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			w.Auth = d.Val()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
 		}
-	} else if refName.IsTag() {
-		if !hc.RepoInfo.LatestTag && refName != hc.RepoInfo.ReferenceName {
-			return http.StatusBadRequest, fmt.Errorf("event: push to tag %s", refName)
+
+		for d.NextBlock(0) {
+			var val *string
+
+			switch d.Val() {
+			case "auth":
+				val = &w.Auth
+			case "signature_header":
+				val = &w.SignatureHeader
+			case "signature_prefix":
+				val = &w.SignaturePrefix
+			case "token_param":
+				val = &w.TokenParam
+			case "timestamp_header":
+				val = &w.TimestampHeader
+			case "timestamp_tolerance":
+				var tolerance string
+				if !d.AllArgs(&tolerance) {
+					return d.ArgErr()
+				}
+
+				dur, err := caddy.ParseDuration(tolerance)
+				if err != nil {
+					return d.Errf("invalid duration '%s': %v", tolerance, err)
+				}
+				w.TimestampTolerance = caddy.Duration(dur)
+				continue
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+
+			if !d.AllArgs(val) {
+				return d.ArgErr()
+			}
 		}
-	} else {
-		// return error so the repo doesn't update
-		return http.StatusBadRequest, fmt.Errorf("refName is neither a tag or a branch")
 	}
 
-	return http.StatusOK, nil
+	return nil
 }
 
 // Interface guards.
 var (
-	_ caddy.Module    = (*Webhook)(nil)
-	_ webhook.Webhook = (*Webhook)(nil)
+	_ caddy.Module          = (*Webhook)(nil)
+	_ caddy.Provisioner     = (*Webhook)(nil)
+	_ caddy.Validator       = (*Webhook)(nil)
+	_ webhook.Webhook       = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
 )
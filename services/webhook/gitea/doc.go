@@ -0,0 +1,3 @@
+// Package gitea implements the webhook services compatible with Gitea (and
+// its fork Forgejo) and Gogs.
+package gitea
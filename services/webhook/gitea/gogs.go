@@ -0,0 +1,48 @@
+package gitea
+
+import (
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(Gogs{})
+}
+
+// Gogs implements a hook type which can be used to host the a project
+// maintained on Gogs. Gitea is a fork of Gogs and hence the events are
+// handled the same way.
+type Gogs struct{}
+
+// CaddyModule returns the caddy module information.
+func (Gogs) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.webhook.gogs",
+		New: func() caddy.Module { return new(Gogs) },
+	}
+}
+
+// Handle implements the webhook.Webhook interface.
+func (Gogs) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+	// Gogs delivers the tags only in the create events.
+	return handle(req, hc, "create", "Gogs")
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. The hook has
+// no options. Syntax:
+//
+// 	gogs
+func (*Gogs) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	return unmarshalCaddyfile(d)
+}
+
+// Interface guards.
+var (
+	_ caddy.Module          = (*Gogs)(nil)
+	_ webhook.Webhook       = (*Gogs)(nil)
+	_ caddyfile.Unmarshaler = (*Gogs)(nil)
+)
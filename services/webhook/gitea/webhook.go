@@ -0,0 +1,220 @@
+package gitea
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(Webhook{})
+}
+
+// Webhook implements a hook type which can be used to host the a project
+// maintained on Gitea or Forgejo.
+type Webhook struct{}
+
+type pushBody struct {
+	Ref string `json:"ref"`
+}
+
+type createBody struct {
+	Ref     string `json:"ref"`
+	RefType string `json:"ref_type"`
+}
+
+type releaseBody struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+	} `json:"release"`
+}
+
+type repoBody struct {
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+}
+
+// CaddyModule returns the caddy module information.
+func (Webhook) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.webhook.gitea",
+		New: func() caddy.Module { return new(Webhook) },
+	}
+}
+
+// Handle implements the webhook.Webhook interface.
+func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+	// Forgejo sends the Gitea headers as well as its own.
+	return handle(req, hc, "push", "Gitea", "Forgejo")
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. The hook has
+// no options. Syntax:
+//
+// 	gitea
+func (*Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	return unmarshalCaddyfile(d)
+}
+
+// handle handles the request of a hook that sends the headers
+// `X-<vendor>-Event` and `X-<vendor>-Signature` for any of vendors.
+//
+// A new branch is delivered in both a push and a create event, so only the
+// push events update the branches. The tags are updated only by the events
+// of type tagEvent, since a new tag is delivered in both events by some
+// vendors and only in the create event by others.
+func handle(req *http.Request, hc *webhook.HookConf, tagEvent string, vendors ...string) (int, error) {
+	if err := webhook.ValidateRequest(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return webhook.ReadErrorStatus(err), err
+	}
+
+	signature := vendorHeader(req, "Signature", vendors)
+	if signature != "" || hc.Secret != "" {
+		err = webhook.ValidateSignature(sha256.New, hc.Secret, body, signature, "")
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	event := vendorHeader(req, "Event", vendors)
+	if event == "" {
+		return http.StatusBadRequest, fmt.Errorf("header 'X-%s-Event' missing", vendors[0])
+	}
+
+	err = validateRepo(body, hc)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	switch event {
+	case "push", "create":
+		var refName plumbing.ReferenceName
+
+		refName, err = eventRef(event, body)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		if event == "create" && refName.IsBranch() || refName.IsTag() && event != tagEvent {
+			return http.StatusOK, webhook.ErrNoUpdate
+		}
+
+		err = webhook.ValidateRef(refName, &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	case "release":
+		var rBody releaseBody
+
+		err = json.Unmarshal(body, &rBody)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		if rBody.Action != "published" {
+			return http.StatusBadRequest, fmt.Errorf("event: release %s", rBody.Action)
+		}
+
+		err = webhook.ValidateRelease(rBody.Release.TagName, &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	default:
+		return http.StatusBadRequest, fmt.Errorf("cannot handle %q event", event)
+	}
+
+	return http.StatusOK, nil
+}
+
+// eventRef returns the ref pushed to or created in the push or create event
+// in body.
+func eventRef(event string, body []byte) (plumbing.ReferenceName, error) {
+	if event == "push" {
+		var rBody pushBody
+		if err := json.Unmarshal(body, &rBody); err != nil {
+			return "", err
+		}
+
+		return plumbing.ReferenceName(rBody.Ref), nil
+	}
+
+	var rBody createBody
+	if err := json.Unmarshal(body, &rBody); err != nil {
+		return "", err
+	}
+
+	// The ref of create event is the short name of branch or tag.
+	switch rBody.RefType {
+	case "tag":
+		return plumbing.NewTagReferenceName(rBody.Ref), nil
+	case "branch":
+		return plumbing.NewBranchReferenceName(rBody.Ref), nil
+	default:
+		return "", fmt.Errorf("event: create %q", rBody.RefType)
+	}
+}
+
+// validateRepo validates that the event in body is of the repository of hc.
+func validateRepo(body []byte, hc *webhook.HookConf) error {
+	if !hc.MatchRepo {
+		return nil
+	}
+
+	var rBody repoBody
+	if err := json.Unmarshal(body, &rBody); err != nil {
+		return err
+	}
+
+	repo := rBody.Repository
+	return webhook.ValidateRepo([]string{repo.CloneURL, repo.SSHURL, repo.HTMLURL}, hc)
+}
+
+// vendorHeader returns the first non-empty header `X-<vendor>-<name>`.
+func vendorHeader(req *http.Request, name string, vendors []string) string {
+	for _, vendor := range vendors {
+		if val := req.Header.Get("X-" + vendor + "-" + name); val != "" {
+			return val
+		}
+	}
+
+	return ""
+}
+
+// unmarshalCaddyfile ensures that the hook has no options.
+func unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		if d.NextBlock(0) {
+			return d.Errf("unrecognized subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ caddy.Module          = (*Webhook)(nil)
+	_ webhook.Webhook       = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
+)
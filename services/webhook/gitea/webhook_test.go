@@ -0,0 +1,103 @@
+package gitea
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit"
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func TestHandleNewRef(t *testing.T) {
+	const (
+		branchPush   = `{"ref": "refs/heads/master"}`
+		branchCreate = `{"ref": "master", "ref_type": "branch"}`
+		tagPush      = `{"ref": "refs/tags/v1.0.0"}`
+		tagCreate    = `{"ref": "v1.0.0", "ref_type": "tag"}`
+	)
+
+	tests := []struct {
+		name   string
+		hook   webhook.Webhook
+		vendor string
+		event  string
+		body   string
+		info   caddygit.RepositoryInfo
+		status int
+		err    error
+	}{
+		{
+			name:   "gitea branch push",
+			hook:   Webhook{},
+			vendor: "Gitea",
+			event:  "push",
+			body:   branchPush,
+			info:   caddygit.RepositoryInfo{ReferenceName: plumbing.NewBranchReferenceName("master")},
+			status: http.StatusOK,
+		},
+		{
+			name:   "gitea branch create",
+			hook:   Webhook{},
+			vendor: "Gitea",
+			event:  "create",
+			body:   branchCreate,
+			info:   caddygit.RepositoryInfo{ReferenceName: plumbing.NewBranchReferenceName("master")},
+			status: http.StatusOK,
+			err:    webhook.ErrNoUpdate,
+		},
+		{
+			name:   "gitea tag push",
+			hook:   Webhook{},
+			vendor: "Gitea",
+			event:  "push",
+			body:   tagPush,
+			info:   caddygit.RepositoryInfo{LatestTag: true},
+			status: http.StatusOK,
+		},
+		{
+			name:   "gitea tag create",
+			hook:   Webhook{},
+			vendor: "Gitea",
+			event:  "create",
+			body:   tagCreate,
+			info:   caddygit.RepositoryInfo{LatestTag: true},
+			status: http.StatusOK,
+			err:    webhook.ErrNoUpdate,
+		},
+		{
+			name:   "gogs tag push",
+			hook:   Gogs{},
+			vendor: "Gogs",
+			event:  "push",
+			body:   tagPush,
+			info:   caddygit.RepositoryInfo{LatestTag: true},
+			status: http.StatusOK,
+			err:    webhook.ErrNoUpdate,
+		},
+		{
+			name:   "gogs tag create",
+			hook:   Gogs{},
+			vendor: "Gogs",
+			event:  "create",
+			body:   tagCreate,
+			info:   caddygit.RepositoryInfo{LatestTag: true},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("X-"+tt.vendor+"-Event", tt.event)
+
+			sc, err := tt.hook.Handle(req, &webhook.HookConf{RepoInfo: tt.info})
+			if err != tt.err || sc != tt.status {
+				t.Fatalf("got %d, %v; want %d, %v", sc, err, tt.status, tt.err)
+			}
+		})
+	}
+}
@@ -1,15 +1,15 @@
 package github
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/go-git/go-git/v5/plumbing"
 
 	"github.com/vrongmeal/caddygit/services/webhook"
@@ -34,6 +34,15 @@ type releaseBody struct {
 	} `json:"release"`
 }
 
+type repoBody struct {
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		GitURL   string `json:"git_url"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+}
+
 // CaddyModule returns the caddy module information.
 func (Webhook) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -50,22 +59,12 @@ func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
 
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return http.StatusRequestTimeout, err
+		return webhook.ReadErrorStatus(err), err
 	}
 
-	signature := req.Header.Get("X-Hub-Signature")
-	if signature != "" {
-		if hc.Secret == "" {
-			return http.StatusBadRequest, fmt.Errorf("empty webhook secret")
-		}
-
-		mac := hmac.New(sha1.New, []byte(hc.Secret))
-		mac.Write(body)
-		expectedMac := hex.EncodeToString(mac.Sum(nil))
-
-		if signature[5:] != expectedMac {
-			return http.StatusBadRequest, fmt.Errorf("inavlid signature")
-		}
+	err = validateSignature(req, hc, body)
+	if err != nil {
+		return http.StatusBadRequest, err
 	}
 
 	event := req.Header.Get("X-Github-Event")
@@ -73,8 +72,18 @@ func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
 		return http.StatusBadRequest, fmt.Errorf("header 'X-Github-Event' missing")
 	}
 
+	// The ping of an organization's hook is not of any repository, so it
+	// would update every repository on a shared server.
+	if event == "ping" {
+		return http.StatusOK, webhook.ErrNoUpdate
+	}
+
+	err = validateRepo(body, hc)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
 	switch event {
-	case "ping":
 	case "push":
 		var rBody pushBody
 
@@ -83,18 +92,9 @@ func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
 			return http.StatusBadRequest, err
 		}
 
-		refName := plumbing.ReferenceName(rBody.Ref)
-		if refName.IsBranch() {
-			if refName != hc.RepoInfo.ReferenceName {
-				return http.StatusBadRequest, fmt.Errorf("event: push to branch %s", refName)
-			}
-		} else if refName.IsTag() {
-			if !hc.RepoInfo.LatestTag && refName != hc.RepoInfo.ReferenceName {
-				return http.StatusBadRequest, fmt.Errorf("event: push to tag %s", refName)
-			}
-		} else {
-			// return error so the repo doesn't update
-			return http.StatusBadRequest, fmt.Errorf("refName is neither a tag or a branch")
+		err = webhook.ValidateRef(plumbing.ReferenceName(rBody.Ref), &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
 		}
 	case "release":
 		var rBody releaseBody
@@ -104,15 +104,9 @@ func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
 			return http.StatusBadRequest, err
 		}
 
-		if rBody.Release.TagName == "" {
-			return http.StatusBadRequest, fmt.Errorf("invalid (empty) tag name")
-		}
-
-		if !hc.RepoInfo.LatestTag {
-			// When release event, if the repo is not configured to fetch latest tag,
-			// don't tick because the other options are either a branch or static tag.
-			// in both the cases, a release shouldn't change the tree.
-			return http.StatusBadRequest, fmt.Errorf("repo not latest tag")
+		err = webhook.ValidateRelease(rBody.Release.TagName, &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
 		}
 	default:
 		return http.StatusBadRequest, fmt.Errorf("cannot handle %q event", event)
@@ -121,8 +115,58 @@ func (Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
 	return http.StatusOK, nil
 }
 
+// validateSignature validates the signature of request if either the
+// signature or the secret is set. The SHA-256 signature is preferred over
+// the legacy SHA-1 signature when both are sent.
+func validateSignature(req *http.Request, hc *webhook.HookConf, body []byte) error {
+	if signature := req.Header.Get("X-Hub-Signature-256"); signature != "" {
+		return webhook.ValidateSignature(sha256.New, hc.Secret, body, signature, "sha256=")
+	}
+
+	signature := req.Header.Get("X-Hub-Signature")
+	if signature == "" && hc.Secret == "" {
+		return nil
+	}
+
+	return webhook.ValidateSignature(sha1.New, hc.Secret, body, signature, "sha1=")
+}
+
+// validateRepo validates that the event in body is of the repository of hc.
+func validateRepo(body []byte, hc *webhook.HookConf) error {
+	if !hc.MatchRepo {
+		return nil
+	}
+
+	var rBody repoBody
+	if err := json.Unmarshal(body, &rBody); err != nil {
+		return err
+	}
+
+	repo := rBody.Repository
+	return webhook.ValidateRepo([]string{repo.CloneURL, repo.SSHURL, repo.GitURL, repo.HTMLURL}, hc)
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. The hook has
+// no options. Syntax:
+//
+// 	github
+func (*Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		if d.NextBlock(0) {
+			return d.Errf("unrecognized subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
 // Interface guards.
 var (
-	_ caddy.Module    = (*Webhook)(nil)
-	_ webhook.Webhook = (*Webhook)(nil)
+	_ caddy.Module          = (*Webhook)(nil)
+	_ webhook.Webhook       = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
 )
@@ -0,0 +1,44 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit"
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func TestHandlePing(t *testing.T) {
+	const (
+		secret = "It's a Secret to Everybody"
+		body   = `{"zen": "Keep it logically awesome.", "hook": {"type": "Organization"}}`
+	)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body)) // nolint:errcheck
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-Github-Event", "ping")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	hc := &webhook.HookConf{
+		Secret: secret,
+		RepoInfo: caddygit.RepositoryInfo{
+			URL:           "https://github.com/vrongmeal/caddygit",
+			ReferenceName: plumbing.NewBranchReferenceName("master"),
+		},
+		MatchRepo: true,
+	}
+
+	sc, err := Webhook{}.Handle(req, hc)
+	if err != webhook.ErrNoUpdate || sc != http.StatusOK {
+		t.Fatalf("got %d, %v; want %d, %v", sc, err, http.StatusOK, webhook.ErrNoUpdate)
+	}
+}
@@ -0,0 +1,2 @@
+// Package gitlab implements the webhook service compatible with Gitlab.
+package gitlab
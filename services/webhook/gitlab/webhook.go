@@ -0,0 +1,176 @@
+package gitlab
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(Webhook{})
+}
+
+// Webhook implements a hook type which can be used to host the a project
+// maintained on Gitlab (gitlab.com or self-managed).
+type Webhook struct {
+	// Releases, if true, handles the `Release Hook` events as well. Since
+	// Gitlab sends a `Tag Push Hook` for the tag of every release, this is
+	// only required when tags are not pushed, e.g., when created along with
+	// the release from the UI.
+	Releases bool `json:"releases,omitempty"`
+}
+
+type pushBody struct {
+	Ref string `json:"ref"`
+}
+
+type releaseBody struct {
+	Action string `json:"action"`
+	Tag    string `json:"tag"`
+}
+
+type repoBody struct {
+	Project struct {
+		HTTPURL string `json:"git_http_url"`
+		SSHURL  string `json:"git_ssh_url"`
+		WebURL  string `json:"web_url"`
+	} `json:"project"`
+}
+
+// CaddyModule returns the caddy module information.
+func (Webhook) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.webhook.gitlab",
+		New: func() caddy.Module { return new(Webhook) },
+	}
+}
+
+// Handle implements the webhook.Webhook interface.
+func (w Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+	if err := webhook.ValidateRequest(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return webhook.ReadErrorStatus(err), err
+	}
+
+	token := req.Header.Get("X-Gitlab-Token")
+	if token != "" || hc.Secret != "" {
+		if hc.Secret == "" {
+			return http.StatusBadRequest, fmt.Errorf("empty webhook secret")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(hc.Secret)) != 1 {
+			return http.StatusBadRequest, fmt.Errorf("invalid token")
+		}
+	}
+
+	event := req.Header.Get("X-Gitlab-Event")
+	if event == "" {
+		return http.StatusBadRequest, fmt.Errorf("header 'X-Gitlab-Event' missing")
+	}
+
+	err = validateRepo(body, hc)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	switch event {
+	case "Push Hook", "Tag Push Hook":
+		var rBody pushBody
+
+		err = json.Unmarshal(body, &rBody)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		err = webhook.ValidateRef(plumbing.ReferenceName(rBody.Ref), &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	case "Release Hook":
+		if !w.Releases {
+			return http.StatusBadRequest, fmt.Errorf("release events not enabled")
+		}
+
+		var rBody releaseBody
+
+		err = json.Unmarshal(body, &rBody)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		if rBody.Action != "create" {
+			return http.StatusBadRequest, fmt.Errorf("event: release %s", rBody.Action)
+		}
+
+		err = webhook.ValidateRelease(rBody.Tag, &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	default:
+		return http.StatusBadRequest, fmt.Errorf("cannot handle %q event", event)
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateRepo validates that the event in body is of the repository of hc.
+func validateRepo(body []byte, hc *webhook.HookConf) error {
+	if !hc.MatchRepo {
+		return nil
+	}
+
+	var rBody repoBody
+	if err := json.Unmarshal(body, &rBody); err != nil {
+		return err
+	}
+
+	project := rBody.Project
+	return webhook.ValidateRepo([]string{project.HTTPURL, project.SSHURL, project.WebURL}, hc)
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. Syntax:
+//
+// 	gitlab {
+// 		releases
+// 	}
+func (w *Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "releases":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				w.Releases = true
+
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ caddy.Module          = (*Webhook)(nil)
+	_ webhook.Webhook       = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
+)
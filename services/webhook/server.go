@@ -0,0 +1,347 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddytls"
+	"github.com/dustin/go-humanize"
+)
+
+// ServerOpts configures the server that the webhook service runs when used
+// with the git app (not the HTTP handler).
+type ServerOpts struct {
+	// Listen is the address (`host:port`) the server binds to. If the port
+	// is omitted, the service's port is used. Defaults to `0.0.0.0`.
+	Listen string `json:"listen,omitempty"`
+
+	// TLS, if set, serves the webhook requests over HTTPS.
+	TLS *TLSOpts `json:"tls,omitempty"`
+
+	// ReadTimeout and WriteTimeout are the maximum durations for reading the
+	// entire request and writing the response. No timeout if zero.
+	ReadTimeout  caddy.Duration `json:"read_timeout,omitempty"`
+	WriteTimeout caddy.Duration `json:"write_timeout,omitempty"`
+
+	// MaxBodySize is the maximum size of request body in bytes. Requests
+	// with larger bodies are responded to with status request entity too
+	// large. No limit if zero.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+}
+
+// TLSOpts are the certificates of the webhook server. Either the certificate
+// and key files or the managed names should be set.
+type TLSOpts struct {
+	// CertFile and KeyFile are the PEM encoded certificate (chain) and its
+	// private key.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+
+	// Managed are the names whose certificates are obtained and renewed by
+	// Caddy's `tls` app as per its automation policies.
+	Managed []string `json:"managed,omitempty"`
+}
+
+// UnmarshalCaddyfileOption parses the sub-directive the dispenser is at into
+// o. It returns false if the sub-directive is not of server. Syntax:
+//
+// 	listen        <address>
+// 	tls           [<cert_file> <key_file>] {
+// 		cert_file <file>
+// 		key_file  <file>
+// 		managed   <names...>
+// 	}
+// 	read_timeout  <duration>
+// 	write_timeout <duration>
+// 	max_body_size <size>
+func (o *ServerOpts) UnmarshalCaddyfileOption(d *caddyfile.Dispenser) (bool, error) {
+	switch d.Val() {
+	case "listen":
+		if !d.AllArgs(&o.Listen) {
+			return true, d.ArgErr()
+		}
+
+	case "tls":
+		opts, err := unmarshalTLSOpts(d)
+		if err != nil {
+			return true, err
+		}
+		o.TLS = opts
+
+	case "read_timeout":
+		return true, durationArg(d, &o.ReadTimeout)
+
+	case "write_timeout":
+		return true, durationArg(d, &o.WriteTimeout)
+
+	case "max_body_size":
+		var size string
+		if !d.AllArgs(&size) {
+			return true, d.ArgErr()
+		}
+
+		n, err := humanize.ParseBytes(size)
+		if err != nil {
+			return true, d.Errf("invalid size '%s': %v", size, err)
+		}
+		o.MaxBodySize = int64(n)
+
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// unmarshalTLSOpts parses the `tls` sub-directive.
+func unmarshalTLSOpts(d *caddyfile.Dispenser) (*TLSOpts, error) {
+	opts := &TLSOpts{}
+	if d.NextArg() {
+		opts.CertFile = d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		opts.KeyFile = d.Val()
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "cert_file":
+			if !d.AllArgs(&opts.CertFile) {
+				return nil, d.ArgErr()
+			}
+		case "key_file":
+			if !d.AllArgs(&opts.KeyFile) {
+				return nil, d.ArgErr()
+			}
+		case "managed":
+			names := d.RemainingArgs()
+			if len(names) == 0 {
+				return nil, d.ArgErr()
+			}
+			opts.Managed = append(opts.Managed, names...)
+		default:
+			return nil, d.Errf("unrecognized tls subdirective '%s'", d.Val())
+		}
+	}
+
+	return opts, nil
+}
+
+// validate ensures o's configuration is valid.
+func (o *ServerOpts) validate() error {
+	if o.ReadTimeout < 0 || o.WriteTimeout < 0 || o.MaxBodySize < 0 {
+		return fmt.Errorf("read_timeout, write_timeout and max_body_size should be positive")
+	}
+
+	if o.TLS == nil {
+		return nil
+	}
+
+	files := o.TLS.CertFile != "" || o.TLS.KeyFile != ""
+	if files && (o.TLS.CertFile == "" || o.TLS.KeyFile == "") {
+		return fmt.Errorf("both tls cert_file and key_file should be set")
+	}
+
+	if files == (len(o.TLS.Managed) > 0) {
+		return fmt.Errorf("tls requires either cert_file and key_file or managed names")
+	}
+
+	return nil
+}
+
+// server is the HTTP(S) server of webhook service.
+type server struct {
+	opts      *ServerOpts
+	addr      string
+	tlsConfig *tls.Config
+	tlsApp    *caddytls.TLS
+}
+
+// newServer sets up the server as per opts, given the default port.
+func newServer(ctx caddy.Context, opts *ServerOpts, port uint16) (*server, error) {
+	srv := &server{
+		opts: opts,
+		addr: listenAddr(opts.Listen, port),
+	}
+
+	if opts.TLS == nil {
+		return srv, nil
+	}
+
+	if opts.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLS.CertFile, opts.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load certificate: %v", err)
+		}
+
+		srv.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		return srv, nil
+	}
+
+	tlsAppIface, err := ctx.App("tls")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get tls app: %v", err)
+	}
+
+	var ok bool
+	srv.tlsApp, ok = tlsAppIface.(*caddytls.TLS)
+	if !ok {
+		return nil, fmt.Errorf("invalid tls app %T", tlsAppIface)
+	}
+
+	policies := caddytls.ConnectionPolicies{new(caddytls.ConnectionPolicy)}
+	err = policies.Provision(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up tls connection policy: %v", err)
+	}
+	srv.tlsConfig = policies.TLSConfig(ctx)
+
+	return srv, nil
+}
+
+// listenAddr returns the address to bind to. The host defaults to all IPv4
+// interfaces and the port to the given port.
+func listenAddr(listen string, port uint16) string {
+	if _, _, err := net.SplitHostPort(listen); err == nil {
+		return listen
+	}
+
+	host := strings.Trim(listen, "[]")
+	if host == "" {
+		host = "0.0.0.0"
+	}
+
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
+
+// serve serves the handler until ctx is canceled. It returns nil if the
+// server is shut down gracefully.
+func (s *server) serve(ctx context.Context, handler http.Handler) error {
+	if s.opts.MaxBodySize > 0 {
+		handler = maxBodyHandler(handler, s.opts.MaxBodySize)
+	}
+
+	if s.tlsApp != nil {
+		if err := s.tlsApp.Manage(s.opts.TLS.Managed); err != nil {
+			return fmt.Errorf("cannot manage certificates: %v", err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+
+	httpServer := http.Server{
+		Handler:      handler,
+		ReadTimeout:  time.Duration(s.opts.ReadTimeout),
+		WriteTimeout: time.Duration(s.opts.WriteTimeout),
+	}
+
+	errChan := make(chan error)
+	go func(srv *http.Server, errs chan<- error) {
+		errs <- srv.Serve(ln)
+	}(&httpServer, errChan)
+
+	select {
+	case <-ctx.Done():
+		// 15 seconds to shut down the server should be enough.
+		sdCtx, sdCancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer sdCancel()
+		err = httpServer.Shutdown(sdCtx)
+		if err != nil {
+			return httpServer.Close()
+		}
+
+		return nil
+	case err = <-errChan:
+		return err
+	}
+}
+
+// ErrBodyTooLarge is returned when reading a request body larger than the
+// max body size of the server.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// ReadErrorStatus returns the status code to respond with when the body of
+// a request cannot be read with err.
+func ReadErrorStatus(err error) int {
+	if err == ErrBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusRequestTimeout
+}
+
+// maxBodyHandler limits the size of request body to n bytes.
+func maxBodyHandler(handler http.Handler, n int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = &maxBytesReader{w: w, body: r.Body, n: n}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// maxBytesReader is the body of a request that returns ErrBodyTooLarge
+// once more than n bytes are read. Like http.MaxBytesReader, it makes the
+// server close the connection after the response since the rest of the
+// body is not read.
+type maxBytesReader struct {
+	w    http.ResponseWriter
+	body io.ReadCloser
+	n    int64
+	err  error
+}
+
+// Read implements the io.Reader interface.
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	// Read a byte more than the limit to know if the body exceeds it.
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+
+	n, err := r.body.Read(p)
+	if int64(n) <= r.n {
+		r.n -= int64(n)
+		r.err = err
+		return n, err
+	}
+
+	n, r.n = int(r.n), 0
+	r.err = ErrBodyTooLarge
+	r.w.Header().Set("Connection", "close")
+	return n, r.err
+}
+
+// Close implements the io.Closer interface.
+func (r *maxBytesReader) Close() error {
+	return r.body.Close()
+}
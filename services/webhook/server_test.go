@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readHook is a hook that reads the body of requests like the hooks do.
+func readHook(req *http.Request, _ *HookConf) (int, error) {
+	if _, err := ioutil.ReadAll(req.Body); err != nil {
+		return ReadErrorStatus(err), err
+	}
+
+	return http.StatusOK, nil
+}
+
+func TestStandaloneMaxBody(t *testing.T) {
+	const limit = 16
+
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{name: "within limit", body: strings.Repeat("x", limit), status: http.StatusAccepted},
+		{name: "chunked within limit", body: strings.Repeat("x", limit), chunked: true, status: http.StatusAccepted},
+		{name: "too large", body: strings.Repeat("x", limit+1), status: http.StatusRequestEntityTooLarge},
+		{name: "chunked too large", body: strings.Repeat("x", 2*limit), chunked: true, status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{Hook: hookFunc(readHook), tick: make(chan error, 1)}
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+
+			w := httptest.NewRecorder()
+			maxBodyHandler(http.HandlerFunc(s.serveStandalone), limit).ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("got status %d; want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
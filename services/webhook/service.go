@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 
 	"github.com/vrongmeal/caddygit"
@@ -20,13 +23,20 @@ func init() {
 
 // Service ticks everytime a commit is pushed to the mentioned repository
 // in the specified branch.
+//
+// The requests are responded to as soon as they are handled by the hook,
+// without waiting for the update. Ticks never block: while a tick is
+// pending, i.e., the client is busy updating, further events are coalesced
+// into it so that a single update follows, which updates to the newest ref.
 type Service struct {
 	// Secret to verify the webhook is from correct source.
 	Secret string `json:"secret,omitempty"`
 
 	// Port to run the webhook server on. This server runs only when using the
 	// service with the git plugin (app module). When using with the HTTP
-	// module, this field is rendered useless.
+	// module, this field (and the server options) is rendered useless. If
+	// neither the port nor the listen address is set, the service uses the
+	// shared webhook server of the git app, if any.
 	Port uint16 `json:"port,omitempty"`
 	// Path tells the server which path to accept webhook requests.
 	Path string `json:"path,omitempty"`
@@ -37,10 +47,33 @@ type Service struct {
 	Hook    Webhook         `json:"-"`
 	HookRaw json.RawMessage `json:"hook" caddy:"namespace=git.services.webhook inline_key=type"`
 
-	repo    caddygit.RepositoryInfo
-	addr    string
-	handler http.Handler
-	tick    chan error
+	// DeliveryHeaders are the headers that identify the delivery of an
+	// event. Deliveries already handled in the last DeliveryTTL (1h by
+	// default) are dropped. At most MaxDeliveries (1000 by default) are
+	// remembered. Set DeliveryHeaders to an empty list to not drop any
+	// deliveries.
+	DeliveryHeaders []string       `json:"delivery_headers,omitempty"`
+	DeliveryTTL     caddy.Duration `json:"delivery_ttl,omitempty"`
+	MaxDeliveries   int            `json:"max_deliveries,omitempty"`
+
+	// ReplayWindow, if set, rejects the requests whose signed timestamp is
+	// older (or newer) than the window. Only applies to the hooks that sign
+	// the time of request.
+	ReplayWindow caddy.Duration `json:"replay_window,omitempty"`
+
+	SourcesOpts
+	ServerOpts
+
+	repo       caddygit.RepositoryInfo
+	deliveries *deliveries
+	sources    *sources
+	server     *server
+	shared     *SharedServer
+	handler    http.Handler
+
+	tickMu sync.Mutex
+	tick   chan error
+	closed bool
 }
 
 // CaddyModule returns the Caddy module information.
@@ -51,12 +84,6 @@ func (*Service) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
-// caddyNext to implement the http Handler interface.
-type caddyNext struct{}
-
-// ServeHTTP is an empty handler to implement caddy.Handler interface.
-func (caddyNext) ServeHTTP(w http.ResponseWriter, r *http.Request) error { return nil }
-
 // Provision sets s's configuration for the module.
 func (s *Service) Provision(ctx caddy.Context) error {
 	if s.HookRaw == nil || string(s.HookRaw) == `null` {
@@ -74,7 +101,30 @@ func (s *Service) Provision(ctx caddy.Context) error {
 		return fmt.Errorf("invalid hook configuration")
 	}
 
-	s.addr = net.JoinHostPort("0.0.0.0", fmt.Sprint(s.Port))
+	if s.DeliveryHeaders == nil {
+		s.DeliveryHeaders = DefaultDeliveryHeaders
+	}
+
+	if s.DeliveryTTL == 0 {
+		s.DeliveryTTL = caddy.Duration(time.Hour)
+	}
+
+	if s.MaxDeliveries == 0 {
+		s.MaxDeliveries = 1000
+	}
+
+	s.deliveries = newDeliveries(time.Duration(s.DeliveryTTL), s.MaxDeliveries)
+
+	s.sources, err = newSources(&s.SourcesOpts)
+	if err != nil {
+		return err
+	}
+
+	s.server, err = newServer(ctx, &s.ServerOpts, s.Port)
+	if err != nil {
+		return err
+	}
+
 	s.tick = make(chan error, 1)
 	return nil
 }
@@ -85,7 +135,11 @@ func (s *Service) Validate() error {
 		return fmt.Errorf("Path should be of the format `/path'")
 	}
 
-	return nil
+	if s.DeliveryTTL < 0 || s.MaxDeliveries < 0 || s.ReplayWindow < 0 {
+		return fmt.Errorf("delivery_ttl, max_deliveries and replay_window should be positive")
+	}
+
+	return s.ServerOpts.validate()
 }
 
 // ConfigureRepo configures "s" with the repository information.
@@ -94,20 +148,35 @@ func (s *Service) ConfigureRepo(r caddygit.RepositoryInfo) error {
 	return nil
 }
 
+// Share implements the Sharer interface.
+func (s *Service) Share(ss *SharedServer) bool {
+	if s.Port != 0 || s.Listen != "" {
+		return false
+	}
+
+	s.shared = ss
+	return true
+}
+
 // Start starts the webhook service and ticks for every favorable event.
 func (s *Service) Start(ctx context.Context) <-chan error {
-	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
-		s.tick <- s.ServeHTTP(w, r, caddyNext{})
+	if s.shared != nil {
+		s.shared.add(s)
+		go func() {
+			<-ctx.Done()
+			s.shared.remove(s)
+			s.closeTick()
+		}()
+
+		return s.tick
 	}
 
 	if s.Path != "" {
 		mux := http.NewServeMux()
-		mux.HandleFunc(s.Path, func(w http.ResponseWriter, r *http.Request) {
-			s.tick <- s.ServeHTTP(w, r, caddyNext{})
-		})
+		mux.HandleFunc(s.Path, s.serveStandalone)
 		s.handler = mux
 	} else {
-		s.handler = http.HandlerFunc(handlerFunc)
+		s.handler = http.HandlerFunc(s.serveStandalone)
 	}
 
 	go s.startService(ctx)
@@ -115,45 +184,76 @@ func (s *Service) Start(ctx context.Context) <-chan error {
 	return s.tick
 }
 
+// serveStandalone handles the requests to the server of s. The request is
+// responded to with status accepted if the event updates the repository.
+func (s *Service) serveStandalone(w http.ResponseWriter, r *http.Request) {
+	sc, err := s.handle(r, false)
+	switch {
+	case err == ErrDuplicateDelivery || err == ErrNoUpdate:
+		w.WriteHeader(sc)
+	case err != nil:
+		w.WriteHeader(sc)
+		s.send(err)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		s.send(nil)
+	}
+}
+
 // startService starts the webhook service.
 func (s *Service) startService(ctx context.Context) {
-	server := http.Server{
-		Addr:    s.addr,
-		Handler: s.handler,
-	}
+	s.send(s.server.serve(ctx, s.handler))
+	s.closeTick()
+}
 
-	errChan := make(chan error)
-	go func(srv *http.Server, err chan<- error) {
-		err <- srv.ListenAndServe()
-	}(&server, errChan)
+// send ticks with err without blocking. If a tick is already pending, an
+// update (nil error) replaces it while an error is dropped.
+func (s *Service) send(err error) {
+	s.tickMu.Lock()
+	defer s.tickMu.Unlock()
+
+	if s.closed {
+		return
+	}
 
 	select {
-	case <-ctx.Done():
-		// 15 seconds to shut down the server should be enough.
-		sdCtx, sdCancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer sdCancel()
-		if err := server.Shutdown(sdCtx); err != nil {
-			s.tick <- server.Close()
-			close(s.tick)
-			return
-		}
+	case s.tick <- err:
+		return
+	default:
+	}
 
-		s.tick <- nil
-		close(s.tick)
-	case err := <-errChan:
-		s.tick <- err
-		close(s.tick)
+	if err != nil {
+		return
+	}
+
+	// Only the senders fill the channel and they hold the lock, so it has
+	// room once drained (by this or the receiver).
+	select {
+	case <-s.tick:
+	default:
 	}
+	s.tick <- nil
+}
+
+// closeTick closes the tick channel. Ticks sent afterwards are dropped.
+func (s *Service) closeTick() {
+	s.tickMu.Lock()
+	defer s.tickMu.Unlock()
+
+	s.closed = true
+	close(s.tick)
 }
 
-// ServeHTTP handles requests to the webhook payload URL.
+// ServeHTTP handles requests to the webhook payload URL. It returns
+// ErrDuplicateDelivery if the delivery has already been handled and
+// ErrNoUpdate if the event doesn't update the repository.
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	hc := HookConf{
-		Secret:   s.Secret,
-		RepoInfo: s.repo,
+	sc, err := s.handle(r, false)
+	if err == ErrDuplicateDelivery || err == ErrNoUpdate {
+		w.WriteHeader(sc)
+		return err
 	}
 
-	sc, err := s.Hook.Handle(r, &hc)
 	if err != nil {
 		w.WriteHeader(sc)
 		return caddyhttp.Error(sc, err)
@@ -162,6 +262,194 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyht
 	return next.ServeHTTP(w, r)
 }
 
+// handle handles the webhook request and returns the status code to
+// respond with. If matchRepo is set, only the events of s's repository are
+// accepted.
+func (s *Service) handle(r *http.Request, matchRepo bool) (int, error) {
+	hc := HookConf{
+		Secret:       s.Secret,
+		RepoInfo:     s.repo,
+		ReplayWindow: time.Duration(s.ReplayWindow),
+		MatchRepo:    matchRepo,
+	}
+
+	if s.sources != nil {
+		if err := s.sources.validate(r); err != nil {
+			return http.StatusForbidden, err
+		}
+	}
+
+	sc, err := s.Hook.Handle(r, &hc)
+	if err != nil {
+		return sc, err
+	}
+
+	// The delivery is only recorded once the hook has authenticated the
+	// request so that forged requests cannot mark a delivery as handled.
+	if id := deliveryID(r, s.DeliveryHeaders); id != "" && !s.deliveries.add(id, time.Now()) {
+		return http.StatusOK, ErrDuplicateDelivery
+	}
+
+	return http.StatusOK, nil
+}
+
+// UnmarshalCaddyfile sets up the service from Caddyfile tokens. Syntax:
+//
+// 	webhook {
+// 		secret           <secret>
+// 		port             <port>
+// 		path             <path>
+// 		hook             <type> { ... }
+// 		delivery_headers <headers...>
+// 		delivery_ttl     <duration>
+// 		max_deliveries   <deliveries>
+// 		replay_window    <duration>
+//
+// 		allowed_sources        <ranges...>
+// 		allowed_sources_preset <provider> <file>
+// 		trusted_proxies        <ranges...>
+//
+// 		listen        <address>
+// 		tls           [<cert_file> <key_file>] { ... }
+// 		read_timeout  <duration>
+// 		write_timeout <duration>
+// 		max_body_size <size>
+// 	}
+func (s *Service) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			if err := s.unmarshalCaddyfileOption(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfileOption parses the sub-directive of service the
+// dispenser is at.
+func (s *Service) unmarshalCaddyfileOption(d *caddyfile.Dispenser) error {
+	switch d.Val() {
+	case "secret":
+		if !d.AllArgs(&s.Secret) {
+			return d.ArgErr()
+		}
+
+	case "port":
+		return portArg(d, &s.Port)
+
+	case "path":
+		if !d.AllArgs(&s.Path) {
+			return d.ArgErr()
+		}
+
+	case "hook":
+		raw, err := UnmarshalHookRaw(d)
+		if err != nil {
+			return err
+		}
+		s.HookRaw = raw
+
+	case "delivery_headers":
+		s.DeliveryHeaders = d.RemainingArgs()
+		if len(s.DeliveryHeaders) == 0 {
+			return d.ArgErr()
+		}
+
+	case "delivery_ttl":
+		return durationArg(d, &s.DeliveryTTL)
+
+	case "max_deliveries":
+		var maxDeliveries string
+		if !d.AllArgs(&maxDeliveries) {
+			return d.ArgErr()
+		}
+
+		n, err := strconv.Atoi(maxDeliveries)
+		if err != nil {
+			return d.Errf("invalid number '%s': %v", maxDeliveries, err)
+		}
+		s.MaxDeliveries = n
+
+	case "replay_window":
+		return durationArg(d, &s.ReplayWindow)
+
+	default:
+		return s.unmarshalCaddyfileOpts(d)
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfileOpts parses the sub-directive of the embedded options
+// the dispenser is at.
+func (s *Service) unmarshalCaddyfileOpts(d *caddyfile.Dispenser) error {
+	handled, err := s.SourcesOpts.UnmarshalCaddyfileOption(d)
+	if handled {
+		return err
+	}
+
+	handled, err = s.ServerOpts.UnmarshalCaddyfileOption(d)
+	if handled {
+		return err
+	}
+
+	return d.Errf("unrecognized subdirective '%s'", d.Val())
+}
+
+// portArg parses the only argument of the directive as a port.
+func portArg(d *caddyfile.Dispenser, val *uint16) error {
+	var port string
+	if !d.AllArgs(&port) {
+		return d.ArgErr()
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return d.Errf("invalid port '%s': %v", port, err)
+	}
+
+	*val = uint16(p)
+	return nil
+}
+
+// durationArg parses the only argument of the directive as a duration.
+func durationArg(d *caddyfile.Dispenser, val *caddy.Duration) error {
+	var s string
+	if !d.AllArgs(&s) {
+		return d.ArgErr()
+	}
+
+	dur, err := caddy.ParseDuration(s)
+	if err != nil {
+		return d.Errf("invalid duration '%s': %v", s, err)
+	}
+
+	*val = caddy.Duration(dur)
+	return nil
+}
+
+// UnmarshalHookRaw parses the `hook <type> { ... }` tokens into the JSON
+// of the hook module with its type set.
+func UnmarshalHookRaw(d *caddyfile.Dispenser) (json.RawMessage, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	name := d.Val()
+
+	unm, err := caddyfile.UnmarshalModule(d, "git.services.webhook."+name)
+	if err != nil {
+		return nil, err
+	}
+
+	return caddyconfig.JSONModuleObject(unm, "type", name, nil), nil
+}
+
 // Interface guard.
 var (
 	_ caddygit.Service            = (*Service)(nil)
@@ -169,4 +457,6 @@ var (
 	_ caddy.Provisioner           = (*Service)(nil)
 	_ caddy.Validator             = (*Service)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Service)(nil)
+	_ caddyfile.Unmarshaler       = (*Service)(nil)
+	_ Sharer                      = (*Service)(nil)
 )
@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Sharer is a service that can receive its webhook requests from a server
+// shared with other services.
+type Sharer interface {
+	// Share makes the service use ss. It returns false if the service
+	// runs a server of its own.
+	Share(ss *SharedServer) bool
+}
+
+// SharedServer is a webhook server shared by the webhook services of many
+// clients, so that a single port serves all the repositories.
+//
+// A request is handled by every service whose path is the path of request
+// (or that has no path). When there are many such services, each hook only
+// accepts the events of its own repository. This way a single hook of an
+// organization updates every client whose repository and ref match the
+// event.
+type SharedServer struct {
+	// Port to run the server on if the listen address has no port.
+	Port uint16 `json:"port,omitempty"`
+
+	ServerOpts
+
+	server *server
+
+	mu       sync.RWMutex
+	services []*Service
+}
+
+// Provision sets up ss's configuration.
+func (ss *SharedServer) Provision(ctx caddy.Context) error {
+	var err error
+	ss.server, err = newServer(ctx, &ss.ServerOpts, ss.Port)
+	return err
+}
+
+// Validate ensures ss's configuration is valid.
+func (ss *SharedServer) Validate() error {
+	return ss.ServerOpts.validate()
+}
+
+// Serve runs the server until ctx is canceled.
+func (ss *SharedServer) Serve(ctx context.Context) error {
+	return ss.server.serve(ctx, ss)
+}
+
+// add starts routing the requests to s.
+func (ss *SharedServer) add(s *Service) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.services = append(ss.services, s)
+}
+
+// remove stops routing the requests to s. It waits for the requests being
+// handled to complete.
+func (ss *SharedServer) remove(s *Service) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for i, service := range ss.services {
+		if service == s {
+			ss.services = append(ss.services[:i], ss.services[i+1:]...)
+			return
+		}
+	}
+}
+
+// ServeHTTP hands the request to the services of its path. The request is
+// responded to with status accepted if any service ticks, or status OK if
+// the delivery has already been handled or doesn't update the repository.
+func (ss *SharedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	var services []*Service
+	for _, s := range ss.services {
+		if s.Path == "" || s.Path == r.URL.Path {
+			services = append(services, s)
+		}
+	}
+
+	if len(services) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(ReadErrorStatus(err))
+		return
+	}
+
+	// Only the services of the repository of event should update when the
+	// path does not identify the service.
+	matchRepo := len(services) > 1
+
+	status, ticked, skipped := 0, false, false
+	for _, s := range services {
+		req := r.Clone(r.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var sc int
+		sc, err = s.handle(req, matchRepo)
+		switch {
+		case err == ErrDuplicateDelivery || err == ErrNoUpdate:
+			skipped = true
+		case err == nil:
+			ticked = true
+			s.send(nil)
+		case !matchRepo:
+			s.send(err)
+		}
+
+		if err != nil && status == 0 {
+			status = sc
+		}
+	}
+
+	switch {
+	case ticked:
+		status = http.StatusAccepted
+	case skipped:
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+}
+
+// UnmarshalCaddyfile sets up the server from Caddyfile tokens. Syntax:
+//
+// 	webhook_server [<port>] {
+// 		port          <port>
+// 		listen        <address>
+// 		tls           [<cert_file> <key_file>] { ... }
+// 		read_timeout  <duration>
+// 		write_timeout <duration>
+// 		max_body_size <size>
+// 	}
+func (ss *SharedServer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			d.Prev()
+			if err := portArg(d, &ss.Port); err != nil {
+				return err
+			}
+		}
+
+		for d.NextBlock(0) {
+			if d.Val() == "port" {
+				if err := portArg(d, &ss.Port); err != nil {
+					return err
+				}
+				continue
+			}
+
+			handled, err := ss.ServerOpts.UnmarshalCaddyfileOption(d)
+			if err != nil {
+				return err
+			}
+
+			if !handled {
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ caddy.Provisioner     = (*SharedServer)(nil)
+	_ caddy.Validator       = (*SharedServer)(nil)
+	_ http.Handler          = (*SharedServer)(nil)
+	_ caddyfile.Unmarshaler = (*SharedServer)(nil)
+)
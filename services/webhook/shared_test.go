@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hookFunc is a hook that handles the requests with the function.
+type hookFunc func(*http.Request, *HookConf) (int, error)
+
+func (f hookFunc) Handle(req *http.Request, hc *HookConf) (int, error) { return f(req, hc) }
+
+// errReader is a body that cannot be read.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestSharedServerStatus(t *testing.T) {
+	const limit = 16
+
+	tests := []struct {
+		name   string
+		hook   hookFunc
+		req    func() *http.Request
+		status int
+		tick   bool
+	}{
+		{
+			name: "update",
+			hook: func(*http.Request, *HookConf) (int, error) { return http.StatusOK, nil },
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+			},
+			status: http.StatusAccepted,
+			tick:   true,
+		},
+		{
+			name: "no update",
+			hook: func(*http.Request, *HookConf) (int, error) { return http.StatusOK, ErrNoUpdate },
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "body too large",
+			hook: func(*http.Request, *HookConf) (int, error) { return http.StatusOK, nil },
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 2*limit)))
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "chunked body too large",
+			hook: func(*http.Request, *HookConf) (int, error) { return http.StatusOK, nil },
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 2*limit)))
+				req.ContentLength = -1
+				return req
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "body not read",
+			hook: func(*http.Request, *HookConf) (int, error) { return http.StatusOK, nil },
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/", errReader{})
+				req.ContentLength = -1
+				return req
+			},
+			status: http.StatusRequestTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{Hook: tt.hook, tick: make(chan error, 1)}
+
+			ss := &SharedServer{}
+			ss.add(s)
+
+			w := httptest.NewRecorder()
+			maxBodyHandler(ss, limit).ServeHTTP(w, tt.req())
+
+			if w.Code != tt.status {
+				t.Errorf("got status %d; want %d", w.Code, tt.status)
+			}
+
+			if ticked := len(s.tick) > 0; ticked != tt.tick {
+				t.Errorf("got tick %t; want %t", ticked, tt.tick)
+			}
+		})
+	}
+}
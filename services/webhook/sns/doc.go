@@ -0,0 +1,3 @@
+// Package sns implements the webhook service compatible with AWS CodeCommit
+// triggers delivered through an Amazon SNS HTTP(S) subscription.
+package sns
@@ -0,0 +1,433 @@
+package sns
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+func init() {
+	caddy.RegisterModule(&Webhook{})
+}
+
+// defaultHostPattern matches the hosts of Amazon SNS endpoints.
+const defaultHostPattern = `^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`
+
+// Webhook implements a hook type which can be used to host the a project
+// maintained on AWS CodeCommit, with its triggers published to an Amazon SNS
+// topic that the webhook is subscribed to. Subscriptions to the topic are
+// confirmed automatically.
+type Webhook struct {
+	// TopicARN is the topic whose messages are accepted. It's required
+	// since anyone can subscribe the webhook to their own topic.
+	TopicARN string `json:"topic_arn,omitempty"`
+
+	// CertFile is the PEM encoded certificate to verify the signature of
+	// messages with. Defaults to the certificate at the `SigningCertURL` of
+	// the message.
+	CertFile string `json:"cert_file,omitempty"`
+
+	// HostPattern is the regular expression that the host of the signing
+	// certificate and subscription URL must match. Defaults to the hosts of
+	// Amazon SNS endpoints.
+	HostPattern string `json:"host_pattern,omitempty"`
+
+	cert   *x509.Certificate
+	hostRe *regexp.Regexp
+	client *http.Client
+
+	// certs caches the certificates fetched by their URL.
+	certs   map[string]*x509.Certificate
+	certsMu sync.Mutex
+}
+
+// message is the body of the requests made by SNS.
+type message struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicARN         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// codeCommitEvent is the message published by CodeCommit triggers.
+type codeCommitEvent struct {
+	Records []struct {
+		// EventSourceARN is the ARN of repository, i.e.,
+		// `arn:aws:codecommit:<region>:<account>:<repository>`.
+		EventSourceARN string `json:"eventSourceARN"`
+		CodeCommit     struct {
+			References []struct {
+				Ref     string `json:"ref"`
+				Deleted bool   `json:"deleted"`
+			} `json:"references"`
+		} `json:"codecommit"`
+	} `json:"Records"`
+}
+
+// CaddyModule returns the caddy module information.
+func (*Webhook) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "git.services.webhook.sns",
+		New: func() caddy.Module { return new(Webhook) },
+	}
+}
+
+// Provision set's up w's configuration.
+func (w *Webhook) Provision(ctx caddy.Context) error {
+	if w.HostPattern == "" {
+		w.HostPattern = defaultHostPattern
+	}
+
+	var err error
+	w.hostRe, err = regexp.Compile(w.HostPattern)
+	if err != nil {
+		return fmt.Errorf("invalid host pattern: %v", err)
+	}
+
+	if w.CertFile != "" {
+		var pemBytes []byte
+		pemBytes, err = ioutil.ReadFile(w.CertFile)
+		if err != nil {
+			return fmt.Errorf("cannot read certificate file: %v", err)
+		}
+
+		w.cert, err = parseCert(pemBytes)
+		if err != nil {
+			return fmt.Errorf("invalid certificate file %s: %v", w.CertFile, err)
+		}
+	}
+
+	w.client = &http.Client{Timeout: 10 * time.Second}
+	w.certs = make(map[string]*x509.Certificate)
+	return nil
+}
+
+// Validate ensures w's configuration is valid.
+func (w *Webhook) Validate() error {
+	if w.TopicARN == "" {
+		return fmt.Errorf("topic ARN is required")
+	}
+
+	return nil
+}
+
+// Handle implements the webhook.Webhook interface.
+func (w *Webhook) Handle(req *http.Request, hc *webhook.HookConf) (int, error) {
+	if err := webhook.ValidateRequest(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return webhook.ReadErrorStatus(err), err
+	}
+
+	var msg message
+
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if msg.TopicARN != w.TopicARN {
+		return http.StatusBadRequest, fmt.Errorf("message from topic %s", msg.TopicARN)
+	}
+
+	err = w.verify(&msg)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	err = validateTimestamp(&msg, hc.ReplayWindow)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		err = w.confirm(&msg)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		// Only the notifications update the repository.
+		return http.StatusOK, webhook.ErrNoUpdate
+	case "Notification":
+		var event codeCommitEvent
+
+		err = json.Unmarshal([]byte(msg.Message), &event)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		err = webhook.ValidateRepo(event.repoURLs(), hc)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		var refNames []plumbing.ReferenceName
+		for _, record := range event.Records {
+			for _, ref := range record.CodeCommit.References {
+				if ref.Deleted {
+					continue
+				}
+
+				refNames = append(refNames, plumbing.ReferenceName(ref.Ref))
+			}
+		}
+
+		err = webhook.ValidateAnyRef(refNames, &hc.RepoInfo)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	default:
+		return http.StatusBadRequest, fmt.Errorf("cannot handle %q message", msg.Type)
+	}
+
+	return http.StatusOK, nil
+}
+
+// verify validates the signature of msg.
+func (w *Webhook) verify(msg *message) error {
+	var algo x509.SignatureAlgorithm
+	switch msg.SignatureVersion {
+	case "1":
+		algo = x509.SHA1WithRSA
+	case "2":
+		algo = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("unknown signature version %q", msg.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	cert := w.cert
+	if cert == nil {
+		cert, err = w.fetchCert(msg.SigningCertURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cert.CheckSignature(algo, []byte(msg.stringToSign()), signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	return nil
+}
+
+// validateTimestamp ensures that the signed timestamp of msg is within
+// window of now, if the window is set.
+func validateTimestamp(msg *message, window time.Duration) error {
+	if window == 0 {
+		return nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+
+	return webhook.ValidateTimestamp(timestamp, time.Now(), window)
+}
+
+// confirm confirms the subscription by visiting the subscribe URL of msg.
+func (w *Webhook) confirm(msg *message) error {
+	if err := w.validateURL(msg.SubscribeURL); err != nil {
+		return err
+	}
+
+	resp, err := w.client.Get(msg.SubscribeURL)
+	if err != nil {
+		return fmt.Errorf("cannot confirm subscription: %v", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot confirm subscription: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// fetchCert returns the certificate at certURL.
+func (w *Webhook) fetchCert(certURL string) (*x509.Certificate, error) {
+	w.certsMu.Lock()
+	defer w.certsMu.Unlock()
+
+	if cert, ok := w.certs[certURL]; ok {
+		return cert, nil
+	}
+
+	if err := w.validateURL(certURL); err != nil {
+		return nil, err
+	}
+
+	resp, err := w.client.Get(certURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch certificate: %v", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch certificate: %s", resp.Status)
+	}
+
+	pemBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch certificate: %v", err)
+	}
+
+	cert, err := parseCert(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate %s: %v", certURL, err)
+	}
+
+	w.certs[certURL] = cert
+	return cert, nil
+}
+
+// validateURL ensures that rawURL is an HTTPS URL of an allowed host.
+func (w *Webhook) validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %v", rawURL, err)
+	}
+
+	if u.Scheme != "https" || !w.hostRe.MatchString(u.Hostname()) {
+		return fmt.Errorf("URL %s not allowed", rawURL)
+	}
+
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens. Syntax:
+//
+// 	sns {
+// 		topic_arn    <arn>
+// 		cert_file    <file>
+// 		host_pattern <regexp>
+// 	}
+func (w *Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			var val *string
+
+			switch d.Val() {
+			case "topic_arn":
+				val = &w.TopicARN
+			case "cert_file":
+				val = &w.CertFile
+			case "host_pattern":
+				val = &w.HostPattern
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+
+			if !d.AllArgs(val) {
+				return d.ArgErr()
+			}
+		}
+	}
+
+	return nil
+}
+
+// repoURLs returns the HTTPS URLs of the repositories of event's records.
+func (event *codeCommitEvent) repoURLs() []string {
+	var urls []string
+	for _, record := range event.Records {
+		arn := strings.Split(record.EventSourceARN, ":")
+		if len(arn) != 6 {
+			continue
+		}
+
+		region, repo := arn[3], arn[5]
+		urls = append(urls, "https://git-codecommit."+region+".amazonaws.com/v1/repos/"+repo)
+	}
+
+	return urls
+}
+
+// stringToSign returns the canonical string of msg that is signed by SNS.
+func (msg *message) stringToSign() string {
+	fields := [][2]string{
+		{"Message", msg.Message},
+		{"MessageId", msg.MessageID},
+	}
+
+	if msg.Type == "Notification" {
+		if msg.Subject != "" {
+			fields = append(fields, [2]string{"Subject", msg.Subject})
+		}
+		fields = append(fields,
+			[2]string{"Timestamp", msg.Timestamp},
+			[2]string{"TopicArn", msg.TopicARN},
+			[2]string{"Type", msg.Type},
+		)
+	} else {
+		fields = append(fields,
+			[2]string{"SubscribeURL", msg.SubscribeURL},
+			[2]string{"Timestamp", msg.Timestamp},
+			[2]string{"Token", msg.Token},
+			[2]string{"TopicArn", msg.TopicARN},
+			[2]string{"Type", msg.Type},
+		)
+	}
+
+	var sb strings.Builder
+	for _, field := range fields {
+		sb.WriteString(field[0] + "\n" + field[1] + "\n")
+	}
+
+	return sb.String()
+}
+
+// parseCert parses the first PEM encoded certificate in pemBytes.
+func parseCert(pemBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Interface guards.
+var (
+	_ caddy.Module          = (*Webhook)(nil)
+	_ caddy.Provisioner     = (*Webhook)(nil)
+	_ caddy.Validator       = (*Webhook)(nil)
+	_ webhook.Webhook       = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
+)
@@ -0,0 +1,296 @@
+package sns
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/vrongmeal/caddygit"
+	"github.com/vrongmeal/caddygit/services/webhook"
+)
+
+// event is a CodeCommit trigger for a push to master of repository `app`.
+const event = `{"Records": [{
+	"eventSourceARN": "arn:aws:codecommit:us-east-1:123456789012:app",
+	"codecommit": {"references": [{"ref": "refs/heads/master"}]}
+}]}`
+
+// fixture stands in for AWS: it serves the signing certificate and the
+// subscribe URL, and signs the messages with the key of certificate.
+type fixture struct {
+	key       *rsa.PrivateKey
+	server    *httptest.Server
+	certURL   string
+	fetches   int32
+	confirmed int32
+}
+
+// newFixture starts the server of fixture. It is closed when the test ends.
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.us-east-1.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	f := &fixture{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cert.pem", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.fetches, 1)
+		w.Write(certPEM) // nolint:errcheck
+	})
+	mux.HandleFunc("/confirm", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.confirmed, 1)
+	})
+
+	f.server = httptest.NewTLSServer(mux)
+	t.Cleanup(f.server.Close)
+
+	f.certURL = f.server.URL + "/cert.pem"
+	return f
+}
+
+// hook returns a hook that fetches the certificates from the fixture.
+func (f *fixture) hook() *Webhook {
+	return &Webhook{
+		TopicARN: "arn:aws:sns:us-east-1:123456789012:app-pushes",
+		hostRe:   regexp.MustCompile(`^127\.0\.0\.1$`),
+		client:   f.server.Client(),
+		certs:    make(map[string]*x509.Certificate),
+	}
+}
+
+// sign signs msg with the signature version.
+func (f *fixture) sign(t *testing.T, msg *message, version string) {
+	t.Helper()
+
+	h := crypto.SHA1
+	if version == "2" {
+		h = crypto.SHA256
+	}
+
+	msg.SignatureVersion = version
+	msg.SigningCertURL = f.certURL
+
+	digest := h.New()
+	digest.Write([]byte(msg.stringToSign())) // nolint:errcheck
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, h, digest.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+// notification returns a notification of event published at timestamp.
+func notification(timestamp time.Time) *message {
+	return &message{
+		Type:      "Notification",
+		MessageID: "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicARN:  "arn:aws:sns:us-east-1:123456789012:app-pushes",
+		Message:   event,
+		Timestamp: timestamp.UTC().Format(time.RFC3339),
+	}
+}
+
+// handle makes hook handle msg.
+func handle(t *testing.T, hook *Webhook, msg *message, window time.Duration) (int, error) {
+	t.Helper()
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc := &webhook.HookConf{
+		RepoInfo: caddygit.RepositoryInfo{
+			URL:           "https://git-codecommit.us-east-1.amazonaws.com/v1/repos/app",
+			ReferenceName: plumbing.NewBranchReferenceName("master"),
+		},
+		ReplayWindow: window,
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	return hook.Handle(req, hc)
+}
+
+func TestHandleSignatureVersions(t *testing.T) {
+	f := newFixture(t)
+
+	for _, version := range []string{"1", "2"} {
+		t.Run("v"+version, func(t *testing.T) {
+			msg := notification(time.Now())
+			f.sign(t, msg, version)
+
+			sc, err := handle(t, f.hook(), msg, 0)
+			if err != nil || sc != http.StatusOK {
+				t.Fatalf("got %d, %v; want %d, <nil>", sc, err, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestHandleBadSignature(t *testing.T) {
+	f := newFixture(t)
+
+	tests := map[string]func(msg *message){
+		"tampered message": func(msg *message) { msg.Message = `{"Records": []}` },
+		"tampered topic":   func(msg *message) { msg.TopicARN += "-other" },
+		"unknown version":  func(msg *message) { msg.SignatureVersion = "3" },
+		"not base64":       func(msg *message) { msg.Signature = "%%%" },
+	}
+
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			msg := notification(time.Now())
+			f.sign(t, msg, "2")
+			tamper(msg)
+
+			sc, err := handle(t, f.hook(), msg, 0)
+			if err == nil || sc != http.StatusBadRequest {
+				t.Fatalf("got %d, %v; want %d and an error", sc, err, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestHandleCertHost(t *testing.T) {
+	f := newFixture(t)
+
+	tests := map[string]string{
+		"host not allowed": f.certURL,
+		"not https":        "http://sns.us-east-1.amazonaws.com/cert.pem",
+		"lookalike host":   "https://sns.us-east-1.amazonaws.com.example.com/cert.pem",
+	}
+
+	for name, certURL := range tests {
+		t.Run(name, func(t *testing.T) {
+			hook := f.hook()
+			hook.hostRe = regexp.MustCompile(defaultHostPattern)
+
+			msg := notification(time.Now())
+			f.sign(t, msg, "2")
+			msg.SigningCertURL = certURL
+
+			if _, err := handle(t, hook, msg, 0); err == nil {
+				t.Fatal("got <nil>; want an error")
+			}
+		})
+	}
+
+	if fetches := atomic.LoadInt32(&f.fetches); fetches != 0 {
+		t.Errorf("certificate fetched %d times from a host not allowed", fetches)
+	}
+}
+
+func TestHandleReplayWindow(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		name   string
+		age    time.Duration
+		window time.Duration
+		ok     bool
+	}{
+		{name: "within window", age: time.Minute, window: 5 * time.Minute, ok: true},
+		{name: "stale", age: time.Hour, window: 5 * time.Minute, ok: false},
+		{name: "future", age: -time.Hour, window: 5 * time.Minute, ok: false},
+		{name: "no window", age: time.Hour, window: 0, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := notification(time.Now().Add(-tt.age))
+			f.sign(t, msg, "2")
+
+			_, err := handle(t, f.hook(), msg, tt.window)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v; want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHandleSubscriptionConfirmation(t *testing.T) {
+	f := newFixture(t)
+
+	msg := &message{
+		Type:         "SubscriptionConfirmation",
+		MessageID:    "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		Token:        "2336412f37",
+		TopicARN:     "arn:aws:sns:us-east-1:123456789012:app-pushes",
+		Message:      "You have chosen to subscribe to the topic.",
+		SubscribeURL: f.server.URL + "/confirm",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+	f.sign(t, msg, "1")
+
+	sc, err := handle(t, f.hook(), msg, 0)
+	if err != webhook.ErrNoUpdate || sc != http.StatusOK {
+		t.Fatalf("got %d, %v; want %d, %v", sc, err, http.StatusOK, webhook.ErrNoUpdate)
+	}
+
+	if confirmed := atomic.LoadInt32(&f.confirmed); confirmed != 1 {
+		t.Errorf("subscription confirmed %d times; want 1", confirmed)
+	}
+}
+
+func TestHandleSubscriptionOtherTopic(t *testing.T) {
+	f := newFixture(t)
+
+	msg := &message{
+		Type:         "SubscriptionConfirmation",
+		MessageID:    "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		Token:        "2336412f37",
+		TopicARN:     "arn:aws:sns:us-east-1:210987654321:other-pushes",
+		Message:      "You have chosen to subscribe to the topic.",
+		SubscribeURL: f.server.URL + "/confirm",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+	f.sign(t, msg, "1")
+
+	sc, err := handle(t, f.hook(), msg, 0)
+	if err == nil || sc != http.StatusBadRequest {
+		t.Fatalf("got %d, %v; want %d and an error", sc, err, http.StatusBadRequest)
+	}
+
+	if confirmed := atomic.LoadInt32(&f.confirmed); confirmed != 0 {
+		t.Errorf("subscription to other topic confirmed %d times", confirmed)
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	if err := (&Webhook{}).Validate(); err == nil {
+		t.Fatal("got <nil>; want an error")
+	}
+}
@@ -0,0 +1,271 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// presetKeys are the keys of hook ranges in the meta JSON of providers.
+var presetKeys = map[string]string{
+	"github": "hooks",
+}
+
+// SourcesOpts restricts the clients that the webhook requests are accepted
+// from. Requests from other clients are responded to with status forbidden.
+type SourcesOpts struct {
+	// AllowedSources are the CIDR ranges (or IP addresses) of the clients.
+	// All clients are allowed if neither sources nor a preset is set.
+	AllowedSources []string `json:"allowed_sources,omitempty"`
+
+	// SourcesPreset allows the clients in the ranges published by a provider.
+	SourcesPreset *SourcesPreset `json:"allowed_sources_preset,omitempty"`
+
+	// TrustedProxies are the CIDR ranges of proxies which are trusted to set
+	// the `X-Forwarded-For` header with the address of client. The header is
+	// read right to left, skipping the addresses of trusted proxies, so the
+	// entries that the client itself prepends are never used.
+	//
+	// Caddy v2.4 has no server-level trusted proxies setting that could be
+	// reused here, so the proxies are configured on the service.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// UnmarshalCaddyfileOption parses the sub-directive the dispenser is at into
+// o. It returns false if the sub-directive is not of sources. Syntax:
+//
+// 	allowed_sources        <ranges...>
+// 	allowed_sources_preset <provider> <file>
+// 	trusted_proxies        <ranges...>
+//
+// The `allowed_sources` and `trusted_proxies` sub-directives can be repeated.
+func (o *SourcesOpts) UnmarshalCaddyfileOption(d *caddyfile.Dispenser) (bool, error) {
+	switch d.Val() {
+	case "allowed_sources":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return true, d.ArgErr()
+		}
+		o.AllowedSources = append(o.AllowedSources, args...)
+
+	case "allowed_sources_preset":
+		preset := &SourcesPreset{}
+		if !d.AllArgs(&preset.Provider, &preset.File) {
+			return true, d.ArgErr()
+		}
+		o.SourcesPreset = preset
+
+	case "trusted_proxies":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return true, d.ArgErr()
+		}
+		o.TrustedProxies = append(o.TrustedProxies, args...)
+
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// SourcesPreset loads the address ranges that a provider sends webhooks from.
+type SourcesPreset struct {
+	// Provider whose ranges are loaded. Only `github` is supported.
+	Provider string `json:"provider,omitempty"`
+
+	// File is the provider's meta JSON, e.g., the response of
+	// `https://api.github.com/meta`. The file is reloaded whenever it is
+	// modified, so it can be refreshed without reloading the config.
+	File string `json:"file,omitempty"`
+}
+
+// sources filters the requests by the address of client.
+type sources struct {
+	allowed []*net.IPNet
+	trusted []*net.IPNet
+	preset  *SourcesPreset
+
+	mu         sync.Mutex
+	presetNets []*net.IPNet
+	presetMod  time.Time
+}
+
+// newSources creates a filter as per opts. It returns nil if all the
+// clients are allowed.
+func newSources(opts *SourcesOpts) (*sources, error) {
+	if len(opts.AllowedSources) == 0 && opts.SourcesPreset == nil {
+		return nil, nil
+	}
+
+	var err error
+	preset := opts.SourcesPreset
+	s := &sources{preset: preset}
+
+	s.allowed, err = parseCIDRs(opts.AllowedSources)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed sources: %v", err)
+	}
+
+	s.trusted, err = parseCIDRs(opts.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %v", err)
+	}
+
+	if preset != nil {
+		if _, ok := presetKeys[preset.Provider]; !ok {
+			return nil, fmt.Errorf("unknown sources preset %q", preset.Provider)
+		}
+
+		err = s.loadPreset()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// validate ensures that the client of req is allowed.
+func (s *sources) validate(req *http.Request) error {
+	ip, err := s.clientIP(req)
+	if err != nil {
+		return err
+	}
+
+	if containsIP(s.allowed, ip) {
+		return nil
+	}
+
+	if s.preset != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		err = s.loadPreset()
+		if err != nil {
+			return err
+		}
+
+		if containsIP(s.presetNets, ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("source %s not allowed", ip)
+}
+
+// clientIP returns the address of client that made req. If the request is
+// from a trusted proxy, the address is the last untrusted address in the
+// `X-Forwarded-For` header.
+func (s *sources) clientIP(req *http.Request) (net.IP, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid remote address %s", req.RemoteAddr)
+	}
+
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && containsIP(s.trusted, ip); i-- {
+		fwdIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fwdIP == nil {
+			break
+		}
+
+		ip = fwdIP
+	}
+
+	return ip, nil
+}
+
+// loadPreset reads the ranges of preset if the file has been modified since
+// it was last read.
+func (s *sources) loadPreset() error {
+	info, err := os.Stat(s.preset.File)
+	if err != nil {
+		return fmt.Errorf("cannot read sources preset: %v", err)
+	}
+
+	if info.ModTime().Equal(s.presetMod) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.preset.File)
+	if err != nil {
+		return fmt.Errorf("cannot read sources preset: %v", err)
+	}
+
+	var meta map[string]json.RawMessage
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return fmt.Errorf("invalid sources preset %s: %v", s.preset.File, err)
+	}
+
+	var ranges []string
+	err = json.Unmarshal(meta[presetKeys[s.preset.Provider]], &ranges)
+	if err != nil {
+		return fmt.Errorf("invalid sources preset %s: %v", s.preset.File, err)
+	}
+
+	nets, err := parseCIDRs(ranges)
+	if err != nil {
+		return fmt.Errorf("invalid sources preset %s: %v", s.preset.File, err)
+	}
+
+	s.presetNets = nets
+	s.presetMod = info.ModTime()
+	return nil
+}
+
+// parseCIDRs parses the CIDR ranges. A plain IP address is taken as the range
+// with only that address.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %s", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// containsIP tells whether any of nets contains ip.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
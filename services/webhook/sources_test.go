@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// request returns a request from remote forwarded for the addresses in each
+// of forwarded as a separate header.
+func request(remote string, forwarded ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = remote
+	for _, fwd := range forwarded {
+		req.Header.Add("X-Forwarded-For", fwd)
+	}
+
+	return req
+}
+
+func TestClientIP(t *testing.T) {
+	s, err := newSources(&SourcesOpts{
+		AllowedSources: []string{"10.0.0.0/8"},
+		TrustedProxies: []string{"172.16.0.0/12", "::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		ip   string
+	}{
+		{
+			name: "direct",
+			req:  request("203.0.113.7:1234"),
+			ip:   "203.0.113.7",
+		},
+		{
+			name: "untrusted remote",
+			req:  request("203.0.113.7:1234", "10.0.0.1"),
+			ip:   "203.0.113.7",
+		},
+		{
+			name: "trusted proxy",
+			req:  request("172.16.0.1:1234", "203.0.113.7"),
+			ip:   "203.0.113.7",
+		},
+		{
+			name: "spoofed leading entries",
+			req:  request("172.16.0.1:1234", "10.0.0.1, 10.0.0.2, 203.0.113.7"),
+			ip:   "203.0.113.7",
+		},
+		{
+			name: "spoofed leading header",
+			req:  request("172.16.0.1:1234", "10.0.0.1", "203.0.113.7"),
+			ip:   "203.0.113.7",
+		},
+		{
+			name: "chain of trusted proxies",
+			req:  request("172.16.0.1:1234", "10.0.0.1, 203.0.113.7, 172.16.0.3, 172.16.0.2"),
+			ip:   "203.0.113.7",
+		},
+		{
+			name: "only trusted proxies",
+			req:  request("172.16.0.1:1234", "172.16.0.3, 172.16.0.2"),
+			ip:   "172.16.0.3",
+		},
+		{
+			name: "invalid entry",
+			req:  request("172.16.0.1:1234", "10.0.0.1, unknown"),
+			ip:   "172.16.0.1",
+		},
+		{
+			name: "ipv6 trusted proxy",
+			req:  request("[::1]:1234", "10.0.0.1, 2001:db8::7"),
+			ip:   "2001:db8::7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := s.clientIP(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ip.String() != tt.ip {
+				t.Fatalf("got %s; want %s", ip, tt.ip)
+			}
+		})
+	}
+}
+
+func TestValidateSources(t *testing.T) {
+	s, err := newSources(&SourcesOpts{
+		AllowedSources: []string{"10.0.0.0/8", "192.168.1.10"},
+		TrustedProxies: []string{"172.16.0.0/12"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		ok   bool
+	}{
+		{name: "allowed", req: request("10.1.2.3:1234"), ok: true},
+		{name: "allowed address", req: request("192.168.1.10:1234"), ok: true},
+		{name: "not allowed", req: request("192.168.1.11:1234"), ok: false},
+		{name: "allowed through proxy", req: request("172.16.0.1:1234", "10.1.2.3"), ok: true},
+		{name: "proxy not allowed", req: request("172.16.0.1:1234"), ok: false},
+		{name: "spoofed through proxy", req: request("172.16.0.1:1234", "10.1.2.3, 203.0.113.7"), ok: false},
+		{name: "spoofed without proxy", req: request("203.0.113.7:1234", "10.1.2.3"), ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.validate(tt.req)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v; want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
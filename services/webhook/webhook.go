@@ -1,8 +1,17 @@
 package webhook
 
 import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
 
 	"github.com/vrongmeal/caddygit"
 )
@@ -15,8 +24,23 @@ type HookConf struct {
 
 	// RepoInfo is the repository information.
 	RepoInfo caddygit.RepositoryInfo
+
+	// ReplayWindow, if non-zero, is the maximum difference between the
+	// signed time of request and now for the hooks that sign it.
+	ReplayWindow time.Duration
+
+	// MatchRepo, if true, makes the hook accept only the events of the
+	// repository at RepoInfo.URL. It is set when the request is handled by
+	// the services of multiple repositories.
+	MatchRepo bool
 }
 
+// ErrNoUpdate is returned by a hook when the request is valid but its event
+// doesn't update the repository, for eg., a ping or the confirmation of a
+// subscription. The request is responded to with status OK and the service
+// does not tick.
+var ErrNoUpdate = errors.New("event does not update the repository")
+
 // Webhook is anything that handles a POST request with events and if the
 // desired event occurs, causes Handle() to return nil error.
 type Webhook interface {
@@ -36,3 +60,169 @@ func ValidateRequest(r *http.Request) error {
 
 	return nil
 }
+
+// ValidateRef validates that a push to refName should update the repository
+// described by info. A branch or a static tag must be the one the repository
+// is set to, while a tag must be accepted by the repository when it tracks
+// the latest tag.
+func ValidateRef(refName plumbing.ReferenceName, info *caddygit.RepositoryInfo) error {
+	switch {
+	case refName.IsBranch():
+		if refName != info.ReferenceName {
+			return fmt.Errorf("event: push to branch %s", refName)
+		}
+	case refName.IsTag():
+		if !info.LatestTag && refName != info.ReferenceName {
+			return fmt.Errorf("event: push to tag %s", refName)
+		}
+		if info.LatestTag && !info.AcceptsTag(refName.Short()) {
+			return fmt.Errorf("event: push to unaccepted tag %s", refName)
+		}
+	default:
+		// return error so the repo doesn't update
+		return fmt.Errorf("refName is neither a tag or a branch")
+	}
+
+	return nil
+}
+
+// ValidateAnyRef validates that a push to refNames should update the
+// repository described by info, i.e., at least one of the refs is valid.
+func ValidateAnyRef(refNames []plumbing.ReferenceName, info *caddygit.RepositoryInfo) error {
+	if len(refNames) == 0 {
+		return fmt.Errorf("event: push to no refs")
+	}
+
+	var err error
+	for _, refName := range refNames {
+		err = ValidateRef(refName, info)
+		if err == nil {
+			return nil
+		}
+	}
+
+	// error of the last ref is returned since the error is similar for all
+	return err
+}
+
+// ValidateRepo validates that the event is of the repository described by
+// hc, given the URLs of the repository in the event. The URLs are compared
+// regardless of the scheme, user, port and `.git` suffix, so an HTTP URL
+// matches the SSH URL of the same repository. It is a no-op unless
+// hc.MatchRepo is set.
+func ValidateRepo(urls []string, hc *HookConf) error {
+	if !hc.MatchRepo {
+		return nil
+	}
+
+	key := repoKey(hc.RepoInfo.URL)
+	for _, u := range urls {
+		if u != "" && repoKey(u) == key {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("event: repository is not %s", hc.RepoInfo.URL)
+}
+
+// repoKey returns the host and path of the repository at URL u, which can
+// also be an SCP-like SSH URL (`user@host:path`) or a local path.
+func repoKey(u string) string {
+	u = strings.ToLower(strings.TrimSpace(u))
+
+	var host, path string
+	colon := strings.Index(u, ":")
+
+	switch {
+	case strings.Contains(u, "://"):
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return u
+		}
+		host, path = parsed.Hostname(), parsed.Path
+	case colon > 0 && !strings.Contains(u[:colon], "/"):
+		host, path = u[:colon], u[colon+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	default:
+		path = u
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return host + "/" + path
+}
+
+// ValidateRelease validates that the release of tag should update the
+// repository described by info.
+func ValidateRelease(tag string, info *caddygit.RepositoryInfo) error {
+	if tag == "" {
+		return fmt.Errorf("invalid (empty) tag name")
+	}
+
+	if !info.LatestTag {
+		// When release event, if the repo is not configured to fetch latest tag,
+		// don't tick because the other options are either a branch or static tag.
+		// in both the cases, a release shouldn't change the tree.
+		return fmt.Errorf("repo not latest tag")
+	}
+
+	if !info.AcceptsTag(tag) {
+		return fmt.Errorf("event: release of unaccepted tag %s", tag)
+	}
+
+	return nil
+}
+
+// ValidateSignature validates that signature is prefix followed by the hex
+// encoded HMAC of body keyed with secret using the hash function h. The MACs
+// are compared in constant time.
+//
+// Providers should validate the signature whenever the secret is set, even if
+// the request has no signature, so that unsigned requests are rejected.
+func ValidateSignature(h func() hash.Hash, secret string, body []byte, signature, prefix string) error {
+	if secret == "" {
+		return fmt.Errorf("empty webhook secret")
+	}
+
+	if signature == "" {
+		return fmt.Errorf("signature missing")
+	}
+
+	if !strings.HasPrefix(signature, prefix) {
+		return fmt.Errorf("invalid signature: expected prefix %q", prefix)
+	}
+
+	actualMac, err := hex.DecodeString(signature[len(prefix):])
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+
+	if !hmac.Equal(actualMac, mac.Sum(nil)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
+
+// ValidateTimestamp validates that timestamp is within window of now. A zero
+// window allows any timestamp.
+func ValidateTimestamp(timestamp, now time.Time, window time.Duration) error {
+	if window == 0 {
+		return nil
+	}
+
+	diff := now.Sub(timestamp)
+	if diff < 0 {
+		diff = -diff
+	}
+
+	if diff > window {
+		return fmt.Errorf("timestamp %s outside window of %s", timestamp.Format(time.RFC3339), window)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+// sign returns the hex encoded HMAC of body keyed with secret.
+func sign(h func() hash.Hash, secret string, body []byte) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body) // nolint:errcheck
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateSignature(t *testing.T) {
+	const secret = "It's a Secret to Everybody"
+	body := []byte("Hello, World!")
+
+	tests := []struct {
+		name      string
+		h         func() hash.Hash
+		secret    string
+		signature string
+		prefix    string
+		ok        bool
+	}{
+		{
+			name:      "sha256",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "sha256=" + sign(sha256.New, secret, body),
+			prefix:    "sha256=",
+			ok:        true,
+		},
+		{
+			name:      "sha1",
+			h:         sha1.New,
+			secret:    secret,
+			signature: "sha1=" + sign(sha1.New, secret, body),
+			prefix:    "sha1=",
+			ok:        true,
+		},
+		{
+			name:      "no prefix",
+			h:         sha256.New,
+			secret:    secret,
+			signature: sign(sha256.New, secret, body),
+			prefix:    "",
+			ok:        true,
+		},
+		{
+			name:      "header shorter than prefix",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "sha",
+			prefix:    "sha256=",
+		},
+		{
+			name:      "missing header",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "",
+			prefix:    "sha256=",
+		},
+		{
+			name:      "missing secret",
+			h:         sha256.New,
+			secret:    "",
+			signature: "sha256=" + sign(sha256.New, "", body),
+			prefix:    "sha256=",
+		},
+		{
+			name:      "bad prefix",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "sha1=" + sign(sha256.New, secret, body),
+			prefix:    "sha256=",
+		},
+		{
+			name:      "bad hex",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "sha256=zz" + sign(sha256.New, secret, body)[2:],
+			prefix:    "sha256=",
+		},
+		{
+			name:      "wrong secret",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "sha256=" + sign(sha256.New, "another secret", body),
+			prefix:    "sha256=",
+		},
+		{
+			name:      "wrong hash",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "sha256=" + sign(sha1.New, secret, body),
+			prefix:    "sha256=",
+		},
+		{
+			name:      "truncated mac",
+			h:         sha256.New,
+			secret:    secret,
+			signature: "sha256=" + sign(sha256.New, secret, body)[:32],
+			prefix:    "sha256=",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSignature(tt.h, tt.secret, body, tt.signature, tt.prefix)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v; want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
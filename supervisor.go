@@ -0,0 +1,161 @@
+package caddygit
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults for supervising services.
+const (
+	DefaultGracePeriod    = 10 * time.Second
+	DefaultRestartBackoff = time.Second
+	MaxRestartBackoff     = time.Minute
+)
+
+// Supervisor keeps the service commands running. A service is restarted
+// with backoff whenever it exits and is stopped gracefully before a new
+// instance of it is started.
+type Supervisor struct {
+	OnStart func(Command)
+	OnExit  func(Command, error)
+
+	mu       sync.Mutex
+	services map[int]*service
+}
+
+// service is a running instance of a service command.
+type service struct {
+	cmd  Command
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start stops the running instance of service with given id, if any, and
+// starts the command as its new instance. If the command cannot be started,
+// it's retried with backoff like a service that exited.
+func (s *Supervisor) Start(id int, cmd Command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.services[id]; ok {
+		old.terminate()
+		delete(s.services, id)
+	}
+
+	ex, wait, err := cmd.start()
+	if err != nil {
+		if s.OnExit != nil {
+			s.OnExit(cmd, err)
+		}
+	} else if s.OnStart != nil {
+		s.OnStart(cmd)
+	}
+
+	svc := &service{
+		cmd:  cmd,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if s.services == nil {
+		s.services = make(map[int]*service)
+	}
+	s.services[id] = svc
+
+	go s.supervise(svc, ex, wait)
+}
+
+// Stop gracefully stops all the services.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for id, svc := range s.services {
+		wg.Add(1)
+		go func(sv *service) {
+			defer wg.Done()
+			sv.terminate()
+		}(svc)
+
+		delete(s.services, id)
+	}
+
+	wg.Wait()
+}
+
+// supervise restarts the service whenever it exits until it's stopped.
+// The backoff is reset if the service ran for long enough.
+func (s *Supervisor) supervise(svc *service, ex *exec.Cmd, wait <-chan error) {
+	defer close(svc.done)
+
+	backoff := DefaultRestartBackoff
+	grace := time.Duration(svc.cmd.GracePeriod)
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+
+	for {
+		started := time.Now()
+
+		if ex != nil {
+			select {
+			case <-svc.stop:
+				stopProcess(ex, wait, grace)
+				return
+
+			case err := <-wait:
+				if s.OnExit != nil {
+					s.OnExit(svc.cmd, err)
+				}
+			}
+		}
+
+		if time.Since(started) > MaxRestartBackoff {
+			backoff = DefaultRestartBackoff
+		}
+
+		select {
+		case <-svc.stop:
+			return
+
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > MaxRestartBackoff {
+			backoff = MaxRestartBackoff
+		}
+
+		var err error
+		ex, wait, err = svc.cmd.start()
+		if err != nil {
+			if s.OnExit != nil {
+				s.OnExit(svc.cmd, err)
+			}
+		} else if s.OnStart != nil {
+			s.OnStart(svc.cmd)
+		}
+	}
+}
+
+// terminate stops the service and waits for it to exit.
+func (svc *service) terminate() {
+	close(svc.stop)
+	<-svc.done
+}
+
+// stopProcess sends SIGTERM to the process group and waits for the grace
+// period before killing it.
+func stopProcess(ex *exec.Cmd, wait <-chan error, grace time.Duration) {
+	_ = syscall.Kill(-ex.Process.Pid, syscall.SIGTERM)
+
+	select {
+	case <-wait:
+	case <-time.After(grace):
+		_ = syscall.Kill(-ex.Process.Pid, syscall.SIGKILL)
+		<-wait
+	}
+}
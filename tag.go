@@ -0,0 +1,103 @@
+package caddygit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// noPrerelease is the constraint token that excludes pre-release versions.
+const noPrerelease = "!prerelease"
+
+// TagConstraint is a semantic version constraint that the tags of the
+// repository are matched against, for eg. `^2.3`, `>=1.0 <2.0` or
+// `~1.2 !prerelease`.
+type TagConstraint struct {
+	raw          string
+	constraints  *semver.Constraints
+	noPrerelease bool
+}
+
+// NewTagConstraint parses the given constraint.
+func NewTagConstraint(c string) (*TagConstraint, error) {
+	tc := &TagConstraint{raw: c}
+
+	var fields []string
+	for _, field := range strings.Fields(c) {
+		if field == noPrerelease {
+			tc.noPrerelease = true
+			continue
+		}
+
+		fields = append(fields, field)
+	}
+
+	constraint := strings.Join(fields, " ")
+	if constraint == "" {
+		constraint = "*"
+	}
+
+	var err error
+	tc.constraints, err = semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag constraint '%s': %v", c, err)
+	}
+
+	return tc, nil
+}
+
+// String returns the constraint as it was given.
+func (tc *TagConstraint) String() string {
+	return tc.raw
+}
+
+// Match parses the tag (short name) as a semantic version and tells if it
+// satisfies the constraint.
+func (tc *TagConstraint) Match(tag string) (*semver.Version, bool) {
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		return nil, false
+	}
+
+	if tc.noPrerelease && v.Prerelease() != "" {
+		return nil, false
+	}
+
+	if !tc.constraints.Check(v) {
+		return nil, false
+	}
+
+	return v, true
+}
+
+// Latest returns the reference name of the highest tag that satisfies the
+// constraint from the given references.
+func (tc *TagConstraint) Latest(refs []*plumbing.Reference) (plumbing.ReferenceName, error) {
+	var (
+		latest    plumbing.ReferenceName
+		latestVer *semver.Version
+	)
+
+	for _, ref := range refs {
+		if !ref.Name().IsTag() {
+			continue
+		}
+
+		v, ok := tc.Match(ref.Name().Short())
+		if !ok {
+			continue
+		}
+
+		if latestVer == nil || v.GreaterThan(latestVer) {
+			latest, latestVer = ref.Name(), v
+		}
+	}
+
+	if latestVer == nil {
+		return latest, errNoTag
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,300 @@
+package caddygit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Armor headers of the signatures.
+const (
+	pgpSignatureBegin = "-----BEGIN PGP SIGNATURE-----"
+	sshSignatureBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureEnd   = "-----END SSH SIGNATURE-----"
+
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "git"
+)
+
+var errNoSignature = errors.New("signature missing")
+
+// SignatureError is returned when the signature of a commit or an annotated
+// tag is either missing or not made by any of the trusted keys.
+type SignatureError struct {
+	// Type is the type of object verified, i.e., commit or tag.
+	Type plumbing.ObjectType
+
+	// Hash of the object verified.
+	Hash plumbing.Hash
+
+	// Err tells why the signature is not trusted.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("untrusted %s %s: %v", e.Type, e.Hash, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// verifier verifies the signatures of commits and tags against the trusted
+// OpenPGP and SSH keys.
+type verifier struct {
+	pgpKeys openpgp.EntityList
+	sshKeys [][]byte
+}
+
+// newVerifier creates a verifier with the trusted keys from given options.
+// If no trusted keys are given, nil is returned.
+func newVerifier(opts *RepositoryOpts) (*verifier, error) {
+	if len(opts.TrustedPGPKeys) == 0 && len(opts.TrustedSSHKeys) == 0 {
+		return nil, nil
+	}
+
+	v := &verifier{}
+
+	for _, file := range opts.TrustedPGPKeys {
+		keys, err := readArmoredKeyRing(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read pgp key '%s': %v", file, err)
+		}
+
+		v.pgpKeys = append(v.pgpKeys, keys...)
+	}
+
+	for _, key := range opts.TrustedSSHKeys {
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse ssh key '%s': %v", key, err)
+		}
+
+		v.sshKeys = append(v.sshKeys, pk.Marshal())
+	}
+
+	return v, nil
+}
+
+// readArmoredKeyRing reads the armored OpenPGP keys from the file.
+func readArmoredKeyRing(file string) (openpgp.EntityList, error) {
+	f, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
+// Verify verifies the object with given hash. Annotated tags are verified
+// with their own signature and commits with the signature of the commit.
+func (v *verifier) Verify(repo *git.Repository, hash plumbing.Hash) error {
+	_, err := repo.TagObject(hash)
+	switch err {
+	case nil:
+		return v.verifyTag(repo, hash)
+	case plumbing.ErrObjectNotFound:
+	default:
+		return err
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+
+	return v.verifyCommit(commit)
+}
+
+// verifyCommit verifies the signature of the commit.
+func (v *verifier) verifyCommit(c *object.Commit) error {
+	sigErr := func(err error) error {
+		return &SignatureError{Type: plumbing.CommitObject, Hash: c.Hash, Err: err}
+	}
+
+	if c.PGPSignature == "" {
+		return sigErr(errNoSignature)
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := c.EncodeWithoutSignature(encoded); err != nil {
+		return err
+	}
+
+	payload, err := readObject(encoded)
+	if err != nil {
+		return err
+	}
+
+	if err := v.verify(payload, c.PGPSignature); err != nil {
+		return sigErr(err)
+	}
+
+	return nil
+}
+
+// verifyTag verifies the signature of the annotated tag. The signature of
+// a tag is appended to the raw tag object, hence the object is split at the
+// beginning of the signature.
+func (v *verifier) verifyTag(repo *git.Repository, hash plumbing.Hash) error {
+	sigErr := func(err error) error {
+		return &SignatureError{Type: plumbing.TagObject, Hash: hash, Err: err}
+	}
+
+	obj, err := repo.Storer.EncodedObject(plumbing.TagObject, hash)
+	if err != nil {
+		return err
+	}
+
+	raw, err := readObject(obj)
+	if err != nil {
+		return err
+	}
+
+	idx := bytes.LastIndex(raw, []byte(pgpSignatureBegin))
+	if sshIdx := bytes.LastIndex(raw, []byte(sshSignatureBegin)); sshIdx > idx {
+		idx = sshIdx
+	}
+
+	if idx < 0 {
+		return sigErr(errNoSignature)
+	}
+
+	if err := v.verify(raw[:idx], string(raw[idx:])); err != nil {
+		return sigErr(err)
+	}
+
+	return nil
+}
+
+// verify verifies the armored signature of payload.
+func (v *verifier) verify(payload []byte, signature string) error {
+	if strings.HasPrefix(strings.TrimSpace(signature), sshSignatureBegin) {
+		return v.verifySSH(payload, signature)
+	}
+
+	if len(v.pgpKeys) == 0 {
+		return fmt.Errorf("no trusted pgp keys")
+	}
+
+	_, err := openpgp.CheckArmoredDetachedSignature(
+		v.pgpKeys,
+		bytes.NewReader(payload),
+		strings.NewReader(signature),
+	)
+	return err
+}
+
+// sshSig is the SSH signature blob as defined in PROTOCOL.sshsig of
+// OpenSSH. The magic preamble is stripped before unmarshalling.
+type sshSig struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is the data that is signed by the SSH key.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// verifySSH verifies the armored SSH signature of payload.
+func (v *verifier) verifySSH(payload []byte, signature string) error {
+	armored := strings.TrimSpace(signature)
+	armored = strings.TrimPrefix(armored, sshSignatureBegin)
+	armored = strings.TrimSuffix(armored, sshSignatureEnd)
+	armored = strings.Join(strings.Fields(armored), "")
+
+	blob, err := base64.StdEncoding.DecodeString(armored)
+	if err != nil {
+		return fmt.Errorf("invalid ssh signature: %v", err)
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return fmt.Errorf("invalid ssh signature: magic preamble missing")
+	}
+
+	var sig sshSig
+	if err := ssh.Unmarshal(blob[len(sshSigMagic):], &sig); err != nil {
+		return fmt.Errorf("invalid ssh signature: %v", err)
+	}
+
+	if sig.Namespace != sshSigNamespace {
+		return fmt.Errorf("ssh signature namespace '%s' not '%s'", sig.Namespace, sshSigNamespace)
+	}
+
+	trusted := false
+	for _, key := range v.sshKeys {
+		if bytes.Equal(key, sig.PublicKey) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("ssh key not trusted")
+	}
+
+	pk, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("ssh signature hash algorithm '%s' not supported", sig.HashAlgorithm)
+	}
+	h.Write(payload) // nolint:errcheck
+
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+
+	var s ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &s); err != nil {
+		return fmt.Errorf("invalid ssh signature: %v", err)
+	}
+
+	return pk.Verify(signed, &s)
+}
+
+// readObject reads the contents of the encoded object.
+func readObject(obj plumbing.EncodedObject) ([]byte, error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() // nolint:errcheck
+
+	return ioutil.ReadAll(r)
+}